@@ -3,52 +3,165 @@ package bes
 import (
 	"context"
 	"fmt"
-	"github.com/olivere/elastic/v7"
 	"net/http"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/olivere/elastic/v7"
 )
 
 const (
-	defaultChannelSize = 10000
-	defaultBatchSize = 100
+	defaultChannelSize    = 10000
+	defaultBatchSize      = 100
 	defaultSubmitInterval = 5
+	defaultWorkers        = 1
 )
 
 type ErrorCallback func(err error)
 
 type BesArgs struct {
-	Client     *elastic.Client
-	Retry        int
-	ChannelSize  int
-	BatchSize    int
+	// Client 没有设置Sink时使用 OlivereSink
+	Client *elastic.Client
+	// Sink 执行bulk请求，设置后忽略Client
+	Sink           Sink
+	Retry          int
+	ChannelSize    int
+	BatchSize      int
 	SubmitInterval int64
+	// BatchBytes bulk请求体的估计字节数达到后提交，0表示不限制
+	BatchBytes int64
+	// MaxBulkBytes 一次bulk请求体的最大字节数，超过时拆分为多个请求，0表示不限制
+	// 应小于es的 http.max_content_length，默认100mb
+	MaxBulkBytes int64
+	// MaxDocBytes 单条数据的最大字节数，超过时放弃并写入死信，默认等于MaxBulkBytes
+	MaxDocBytes int64
+	// FlushInterval 提交间隔，设置后代替以秒为单位的SubmitInterval
+	FlushInterval time.Duration
+	// Lanes 按索引名划分的通道，为空时所有数据共用一个队列
+	Lanes []LaneArgs
+	// Workers 并发提交bulk的协程数
+	Workers int
+	// Backoff 重试的退避策略，默认为带抖动的指数退避
+	Backoff elastic.Backoff
+	// OnConflict 版本冲突的处理方式
+	OnConflict ConflictPolicy
+	// DeadLetter 接收重试失败后放弃的数据，可以为空
+	DeadLetter DeadLetter
+	// Spill es不可用或worker都在忙时写入的磁盘队列，es恢复后按顺序重新提交，可以为空
+	Spill *SpillQueue
+	// Overflow 队列满时 Submit 的处理方式
+	Overflow OverflowPolicy
+	// HealthWindow bulk请求连续失败时，最近一次成功在这个时间内仍视为健康，默认30秒
+	HealthWindow time.Duration
+	// MaxPending 提交中的数据最大条数，达到后停止从队列读取数据，默认 BatchSize*(2*Workers+2) 和 ChannelSize 中较大的一个
+	// 配置Lanes时按通道数和各通道的BatchSize增加，小于这个下限（不含ChannelSize）会死锁，NewBatES会panic
+	MaxPending int
+	// MaxAge 数据提交超过这个时间仍没有成功时放弃，0表示不限制
+	MaxAge time.Duration
+	// Context 父context，取消后正在进行和之后的bulk请求都会失败，仍需要调用Stop
+	Context context.Context
+	// RequestTimeout 单次bulk请求的超时时间，0表示不限制
+	RequestTimeout time.Duration
+	// Hook 每次bulk请求前后调用，可以为空
+	Hook BulkHook
+	// Mirror 每条数据同时提交到另一个BatES，例如迁移中的新集群，重试和队列互相独立
+	// 不会阻塞主集群，Mirror队列满时按它的Overflow处理，仍无法接收的数据计入 Stats.MirrorDropped
+	// 需要在Stop之后再停止Mirror
+	Mirror *BatES
+	// IDFunc 没有指定id时生成id，默认使用Snowflake
+	IDFunc IDFunc
+	// Dedup index操作改为create，409视为重复写入的成功，配合IDFunc避免重复数据
+	Dedup bool
+	// Snowflake 生成id，时钟回退超过 ClockPolicy 的容忍范围时数据按无效数据放弃
 	Snowflake *Snowflake
+	// Callback 报告错误，通道、worker和磁盘队列的协程会依次调用，不会并发，回调中不要长时间阻塞
 	Callback ErrorCallback
 }
 
-type IndexData struct {
-	Data  interface{}
-	Retry int
-}
-
 // BatES es输出器信息
 type BatES struct {
-	Client       *elastic.Client
-	Input chan EsData
-	done chan bool
-	batchSize    int
+	Client         *elastic.Client
+	sink           Sink
+	input          chan EsData
+	inputMu        sync.RWMutex
+	closed         bool
+	closing        chan struct{}
+	submitting     sync.WaitGroup
+	overflow       OverflowPolicy
+	submitStats    SubmitStats
+	stop           chan context.Context
+	stopped        chan struct{}
+	stopOnce       sync.Once
+	queue          *batchQueue
+	lanes          []*lane
+	laneWg         sync.WaitGroup
+	draining       chan struct{}
+	workers        int
+	wg             sync.WaitGroup
+	ctx            context.Context
+	cancel         context.CancelFunc
+	dropped        int64
+	batchSize      int
+	maxBulkBytes   int64
+	maxDocBytes    int64
+	measure        bool
+	flushInterval  time.Duration
 	SubmitInterval int64
-	retry        int
-	DataMap      map[string]IndexData
-	Snowflake *Snowflake
-	Callback ErrorCallback
+	retry          int
+	backoff        elastic.Backoff
+	onConflict     ConflictPolicy
+	deadLetter     DeadLetter
+	spill          *SpillQueue
+	stopReplay     context.CancelFunc
+	replayDone     chan struct{}
+	unhealthy      int32
+	metrics        esMetrics
+	healthWindow   time.Duration
+	slots          chan struct{}
+	pending        int64
+	maxAge         time.Duration
+	idFunc         IDFunc
+	requestTimeout time.Duration
+	hook           BulkHook
+	mirror         *BatES
+	dedup          bool
+	Snowflake      *Snowflake
+	Callback       ErrorCallback
 }
 
 type EsData struct {
-	Id    string
-	Index string
-	Data  interface{}
+	Id    string      `json:"id"`
+	Index string      `json:"index"`
+	Data  interface{} `json:"data"`
+	// Op 操作类型，默认 OpIndex
+	Op       OpType `json:"op,omitempty"`
+	Routing  string `json:"routing,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+	// Version 大于0时使用外部版本号
+	Version     int64  `json:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty"`
+	// IfSeqNo IfPrimaryTerm 乐观并发控制
+	IfSeqNo       *int64 `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int64 `json:"if_primary_term,omitempty"`
+	// Upsert OpUpdate 时文档不存在写入的数据
+	Upsert      interface{} `json:"upsert,omitempty"`
+	DocAsUpsert bool        `json:"doc_as_upsert,omitempty"`
+	// Script OpUpdate 的painless脚本
+	Script          string                 `json:"script,omitempty"`
+	ScriptParams    map[string]interface{} `json:"script_params,omitempty"`
+	ScriptedUpsert  bool                   `json:"scripted_upsert,omitempty"`
+	RetryOnConflict int                    `json:"retry_on_conflict,omitempty"`
+}
+
+// esBatch 一次bulk请求的数据
+type esBatch struct {
+	items []*pending
+	size  int64
+	// priority 所在通道的优先级
+	priority int
+	// done 从磁盘队列读取的batch，提交完成后通知是否成功
+	done chan bool
 }
 
 type MyRetry struct {
@@ -60,7 +173,7 @@ func NewRetry(backoff elastic.Backoff) *MyRetry {
 }
 
 func (r *MyRetry) Retry(ctx context.Context, retry int, req *http.Request, resp *http.Response, err error) (time.Duration, bool, error) {
-	if resp != nil && resp.StatusCode == 429 {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
 		wait, stop := r.backoff.Next(retry)
 		return wait, stop, nil
 	}
@@ -69,8 +182,8 @@ func (r *MyRetry) Retry(ctx context.Context, retry int, req *http.Request, resp
 
 // NewBatES 获取新es输出器
 func NewBatES(p BesArgs) *BatES {
-	if p.Client == nil {
-		panic("client is nil")
+	if p.Client == nil && p.Sink == nil {
+		panic("client and sink are nil")
 	}
 	if p.Snowflake == nil {
 		panic("snowflake is nil")
@@ -84,125 +197,468 @@ func NewBatES(p BesArgs) *BatES {
 	if p.SubmitInterval == 0 {
 		p.SubmitInterval = defaultSubmitInterval
 	}
+	if p.MaxDocBytes <= 0 || (p.MaxBulkBytes > 0 && p.MaxDocBytes > p.MaxBulkBytes) {
+		p.MaxDocBytes = p.MaxBulkBytes
+	}
+	if p.FlushInterval <= 0 {
+		p.FlushInterval = time.Second * time.Duration(p.SubmitInterval)
+	}
+	if p.Workers <= 0 {
+		p.Workers = defaultWorkers
+	}
+	if p.Backoff == nil {
+		p.Backoff = NewBackoff(defaultBackoffInitial, defaultBackoffMax, p.Retry)
+	}
+	if p.Sink == nil {
+		p.Sink = NewOlivereSink(p.Client, NewRetry(p.Backoff))
+	}
+	lanes := newLanes(p)
+	//每个通道可以同时有Workers个batch在队列中等待，按优先级出队
+	queueSize := p.Workers * len(lanes)
+	//每个通道、replay、队列和worker中的batch都满时不能阻塞
+	min, maxBatch := 0, 0
+	for _, l := range lanes {
+		min += l.batchSize
+		if l.batchSize > maxBatch {
+			maxBatch = l.batchSize
+		}
+	}
+	min += maxBatch * (queueSize + p.Workers + 1)
+	if p.MaxPending <= 0 {
+		p.MaxPending = min
+		if p.MaxPending < p.ChannelSize {
+			p.MaxPending = p.ChannelSize
+		}
+	} else if p.MaxPending < min {
+		panic(fmt.Sprintf("max pending %d is less than %d, batches in lanes, queue and workers would deadlock", p.MaxPending, min))
+	}
+	if p.HealthWindow <= 0 {
+		p.HealthWindow = defaultHealthWindow
+	}
+	if p.Callback == nil {
+		p.Callback = func(err error) {}
+	}
+	p.Callback = serialize(p.Callback)
 
+	if p.Context == nil {
+		p.Context = context.Background()
+	}
+	ctx, cancel := context.WithCancel(p.Context)
 	es := &BatES{
-		Client:       p.Client,
-		Input: make(chan EsData, p.ChannelSize),
-		done: make(chan bool),
-		batchSize:    p.BatchSize,
+		Client:         p.Client,
+		sink:           p.Sink,
+		input:          lanes[len(lanes)-1].input,
+		overflow:       p.Overflow,
+		closing:        make(chan struct{}),
+		stop:           make(chan context.Context, 1),
+		stopped:        make(chan struct{}),
+		queue:          newBatchQueue(queueSize),
+		lanes:          lanes,
+		draining:       make(chan struct{}),
+		workers:        p.Workers,
+		ctx:            ctx,
+		cancel:         cancel,
+		batchSize:      p.BatchSize,
+		maxBulkBytes:   p.MaxBulkBytes,
+		maxDocBytes:    p.MaxDocBytes,
+		measure:        measure(p.MaxBulkBytes, p.MaxDocBytes, lanes),
+		flushInterval:  p.FlushInterval,
 		SubmitInterval: p.SubmitInterval,
-		retry:        p.Retry,
-		DataMap:      make(map[string]IndexData),
-		Snowflake: p.Snowflake,
-		Callback: p.Callback,
+		retry:          p.Retry,
+		backoff:        p.Backoff,
+		onConflict:     p.OnConflict,
+		deadLetter:     p.DeadLetter,
+		spill:          p.Spill,
+		replayDone:     make(chan struct{}),
+		healthWindow:   p.HealthWindow,
+		slots:          make(chan struct{}, p.MaxPending),
+		maxAge:         p.MaxAge,
+		idFunc:         p.IDFunc,
+		requestTimeout: p.RequestTimeout,
+		hook:           p.Hook,
+		mirror:         p.Mirror,
+		dedup:          p.Dedup,
+		Snowflake:      p.Snowflake,
+		Callback:       p.Callback,
+	}
+	for i := 0; i < es.workers; i++ {
+		es.wg.Add(1)
+		go es.worker(es.Callback)
+	}
+	if es.spill != nil {
+		var replayCtx context.Context
+		replayCtx, es.stopReplay = context.WithCancel(ctx)
+		go es.replay(replayCtx, es.Callback)
+	} else {
+		close(es.replayDone)
 	}
 	go es.Run(es.Callback)
 	return es
 }
 
-// Stop 停止es输出器
-func (s *BatES) Stop() {
-	s.done <- true
+// Stop 停止接收数据，提交队列中剩余的数据，直到全部成功或ctx结束
+// 返回Stop期间没有写入es的数据条数
+func (s *BatES) Stop(ctx context.Context) (int, error) {
+	var before int64
+	s.stopOnce.Do(func() {
+		//ctx结束时取消正在进行的bulk请求
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.cancel()
+			case <-s.stopped:
+			}
+		}()
+		s.closeInput()
+		before = atomic.LoadInt64(&s.dropped)
+		s.stop <- ctx
+	})
+	<-s.stopped
+	dropped := int(atomic.LoadInt64(&s.dropped) - before)
+	if dropped > 0 {
+		return dropped, fmt.Errorf("es output stopped with %d documents dropped", dropped)
+	}
+	return 0, nil
 }
 
-// Run 启动es输出器
+// Close 同 Stop，只返回错误
+func (s *BatES) Close(ctx context.Context) error {
+	_, err := s.Stop(ctx)
+	return err
+}
+
+// Run 启动es输出器，每个通道按条数、字节数和时间间隔分批交给worker提交
 func (s *BatES) Run(callback ErrorCallback) {
-	ticker := time.NewTicker(time.Second * time.Duration(s.SubmitInterval))
-	defer ticker.Stop()
-	i := 0
-	numCached := 0
-	bulk := s.Client.Bulk()
-	for {
-		select {
-		case item, ok := <-s.Input:
-			i += 1
-			if !ok {
-				continue
-			}
-			id, _ := s.Snowflake.NextID()
-			if item.Id == "" {
-				item.Id = strconv.FormatInt(id, 10)
+	for _, l := range s.lanes {
+		s.laneWg.Add(1)
+		go s.runLane(l, callback)
+	}
+	<-s.stop
+	if s.stopReplay != nil {
+		s.stopReplay()
+	}
+	<-s.replayDone
+	close(s.draining)
+	s.laneWg.Wait()
+	s.queue.close()
+	s.wg.Wait()
+	s.cancel()
+	if n := atomic.LoadInt64(&s.dropped); n > 0 {
+		callback(fmt.Errorf("es output stopped, %d documents dropped in total", n))
+	}
+	close(s.stopped)
+}
+
+// serialize 多个协程都会报告错误，加锁后依次调用callback
+func serialize(callback ErrorCallback) ErrorCallback {
+	var mu sync.Mutex
+	return func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		callback(err)
+	}
+}
+
+func (s *BatES) newBatch(priority int) *esBatch {
+	return &esBatch{priority: priority}
+}
+
+// prepare 补全操作类型和id，检查数据大小，返回bulk请求体中的估计字节数
+// 没有字节数限制时不编码数据，返回0，避免和sink重复编码
+// 已经有id的数据不会重新生成，磁盘队列中的数据重新提交时id不变
+func (s *BatES) prepare(item *EsData) (int64, error) {
+	if s.dedup && (item.Op == "" || item.Op == OpIndex) {
+		item.Op = OpCreate
+	}
+	//update和delete必须指定id
+	if item.Id == "" && item.Op != OpUpdate && item.Op != OpDelete {
+		id, err := s.newID(*item)
+		if err != nil {
+			return 0, err
+		}
+		item.Id = id
+	}
+	if !s.measure {
+		_, err := item.request()
+		return 0, err
+	}
+	size, err := requestSize(*item)
+	if err != nil {
+		return 0, err
+	}
+	if s.maxDocBytes > 0 && size > s.maxDocBytes {
+		return 0, fmt.Errorf("document size %d exceeds max document size %d", size, s.maxDocBytes)
+	}
+	return size, nil
+}
+
+// add 添加数据到batch，mirror为true时同时提交到Mirror
+// 磁盘队列中重新读取的数据在第一次进入时已经镜像过，不再镜像
+func (s *BatES) add(batch *esBatch, item EsData, mirror bool) {
+	size, err := s.prepare(&item)
+	if err != nil {
+		s.Callback(fmt.Errorf("invalid data %+v, drop, err:%s", item.Data, err))
+		atomic.AddInt64(&s.dropped, 1)
+		s.bury([]DeadItem{deadItem(&pending{item: item}, err.Error(), 0)}, s.Callback)
+		return
+	}
+	atomic.AddInt64(&s.metrics.received, 1)
+	if mirror && s.mirror != nil {
+		s.mirrorItem(item)
+	}
+	s.acquire()
+	batch.items = append(batch.items, &pending{item: item, size: size, added: time.Now()})
+	batch.size += size
+}
+
+// measure 设置了字节数限制时才需要计算每条数据的大小
+func measure(maxBulkBytes, maxDocBytes int64, lanes []*lane) bool {
+	if maxBulkBytes > 0 || maxDocBytes > 0 {
+		return true
+	}
+	for _, l := range lanes {
+		if l.batchBytes > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSize 估计bulk请求体中一条数据的字节数，数据无效时返回错误
+func requestSize(item EsData) (int64, error) {
+	req, err := item.request()
+	if err != nil {
+		return 0, err
+	}
+	lines, err := req.Source()
+	if err != nil {
+		return 0, err
+	}
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+	return int64(size), nil
+}
+
+// worker 提交batch
+func (s *BatES) worker(callback ErrorCallback) {
+	defer s.wg.Done()
+	for batch := s.queue.pop(); batch != nil; batch = s.queue.pop() {
+		s.commit(batch, callback)
+	}
+}
+
+// commit 提交batch直到全部完成，可重试的失败数据按退避策略在同一个batch中重试
+func (s *BatES) commit(batch *esBatch, callback ErrorCallback) {
+	ok := s.submit(batch, callback)
+	if batch.done != nil {
+		batch.done <- ok
+	}
+}
+
+// submit 整个请求重试失败时返回false
+func (s *BatES) submit(batch *esBatch, callback ErrorCallback) bool {
+	attempts := 0
+	for len(batch.items) > 0 {
+		if n := s.evict(batch, callback); n > 0 {
+			atomic.AddInt64(&s.dropped, int64(n))
+			if len(batch.items) == 0 {
+				return true
 			}
-			s.DataMap[item.Id] = IndexData{
-				Data:  item.Data,
-				Retry: 0,
+		}
+		n, err := s.flush(s.ctx, batch, callback)
+		atomic.AddInt64(&s.dropped, int64(n))
+		if len(batch.items) == 0 {
+			return true
+		}
+		attempts++
+		wait, ok := s.backoff.Next(attempts)
+		//整个请求失败，超过重试次数或已取消时放弃
+		if err != nil && (!ok || attempts > s.retry || s.ctx.Err() != nil) {
+			n = len(batch.items)
+			switch {
+			case batch.done != nil:
+				//磁盘队列中的数据保留在队列中，稍后重新读取
+				s.release(batch)
+			case s.spill != nil && s.spillBatch(batch) == nil:
+				//已写入磁盘队列，es恢复后重新提交，Stop超时取消时也写入，重启后继续提交
+			default:
+				callback(fmt.Errorf("es bulk request failed %d times, drop %d documents", attempts, n))
+				atomic.AddInt64(&s.dropped, int64(n))
+				s.forget(batch, fmt.Sprintf("bulk request failed %d times, err:%s", attempts, err), callback)
 			}
-			bulk = bulk.Add(elastic.NewBulkIndexRequest().Index(item.Index).Doc(item.Data).Id(item.Id))
-			numCached++
-			if numCached >= s.batchSize {
-				numCached = 0
-				resp, err := bulk.Do(context.Background())
-				if err != nil {
-					callback(fmt.Errorf("es bulk request failed, err:%s", err))
-				}
-				if resp != nil {
-					if resp.Failed() != nil {
-						for _, f := range resp.Failed() {
-							d, ok := s.DataMap[f.Id]
-							if !ok {
-								callback(fmt.Errorf("data id:%s index failed with data missing", f.Id))
-							} else {
-								callback(fmt.Errorf("insert data %+v failed, reason:%+v, cause_by:%+v", d.Data, f.Error.Reason, f.Error.CausedBy))
-								if d.Retry >= s.retry {
-									callback(fmt.Errorf("data:%+v failed %d times, drop", d.Data, d.Retry))
-									delete(s.DataMap, f.Id)
-								} else {
-									bulk = bulk.Add(elastic.NewBulkIndexRequest().Index(f.Index).Doc(d.Data).Id(f.Id))
-									d.Retry += 1
-									s.DataMap[f.Id] = d
-									numCached += 1
-								}
-							}
-						}
-					}
-					if resp.Succeeded() != nil {
-						for _, r := range resp.Succeeded() {
-							delete(s.DataMap, r.Id)
-						}
-					}
-				}
+			return false
+		}
+		if err != nil {
+			atomic.AddInt64(&s.metrics.retried, int64(len(batch.items)))
+		}
+		sleep(s.ctx, wait)
+	}
+	return true
+}
+
+// release batch中的数据已经交给磁盘队列，释放位置
+func (s *BatES) release(batch *esBatch) {
+	s.finish(len(batch.reset()))
+}
+
+// forget 放弃batch中的数据并写入死信
+func (s *BatES) forget(batch *esBatch, reason string, callback ErrorCallback) {
+	items := batch.reset()
+	dead := make([]DeadItem, 0, len(items))
+	for _, p := range items {
+		dead = append(dead, deadItem(p, reason, 0))
+	}
+	s.finish(len(items))
+	s.bury(dead, callback)
+}
+
+// flush 提交batch，超过MaxBulkBytes时拆分为多个bulk请求依次提交，返回丢弃的条数
+// 请求失败时这个请求和之后的数据留在batch中
+func (s *BatES) flush(ctx context.Context, batch *esBatch, callback ErrorCallback) (int, error) {
+	items := batch.reset()
+	dropped := 0
+	for len(items) > 0 {
+		n := s.chunk(items)
+		d, err := s.bulk(ctx, batch, items[:n], callback)
+		dropped += d
+		if err != nil {
+			for _, p := range items {
+				batch.items = append(batch.items, p)
+				batch.size += p.size
 			}
+			return dropped, err
+		}
+		items = items[n:]
+	}
+	return dropped, nil
+}
 
-		case <-ticker.C:
-			i = 0
-			if numCached != 0 {
-				numCached = 0
-				resp, err := bulk.Do(context.Background())
-				if err != nil {
-					callback(fmt.Errorf("es bulk request failed, err:%s", err))
-				}
-				if resp != nil {
-					if resp.Failed() != nil {
-						for _, f := range resp.Failed() {
-							d, ok := s.DataMap[f.Id]
-							if !ok {
-								callback(fmt.Errorf("data id:%s index failed with data missing", f.Id))
-							} else {
-								callback(fmt.Errorf("insert data %+v failed, reason:%+v, cause_by:%+v", d.Data, f.Error.Reason, f.Error.CausedBy))
-								if d.Retry >= s.retry {
-									callback(fmt.Errorf("data:%+v failed %d times, drop", d.Data, d.Retry))
-									delete(s.DataMap, f.Id)
-								} else {
-									bulk = bulk.Add(elastic.NewBulkIndexRequest().Index(f.Index).Doc(d.Data).Id(f.Id))
-									d.Retry += 1
-									s.DataMap[f.Id] = d
-									numCached += 1
-								}
-							}
-						}
-					}
-					if resp.Succeeded() != nil {
-						for _, r := range resp.Succeeded() {
-							delete(s.DataMap, r.Id)
-						}
-					}
-				}
+// chunk 返回不超过MaxBulkBytes的数据条数，至少一条
+func (s *BatES) chunk(items []*pending) int {
+	if s.maxBulkBytes <= 0 {
+		return len(items)
+	}
+	size := items[0].size
+	n := 1
+	for n < len(items) && size+items[n].size <= s.maxBulkBytes {
+		size += items[n].size
+		n++
+	}
+	return n
+}
+
+// bulk 执行一次bulk请求，可重试的失败数据重新加入batch，返回丢弃的条数
+func (s *BatES) bulk(ctx context.Context, batch *esBatch, items []*pending, callback ErrorCallback) (int, error) {
+	dropped := 0
+	docs := make([]EsData, len(items))
+	var size int64
+	for i, p := range items {
+		docs[i] = p.item
+		size += p.size
+	}
+	atomic.AddInt64(&s.metrics.inFlightDocs, int64(len(docs)))
+	atomic.AddInt64(&s.metrics.inFlightBytes, size)
+	results, err := s.doBulk(ctx, docs, size)
+	atomic.AddInt64(&s.metrics.inFlightDocs, -int64(len(docs)))
+	atomic.AddInt64(&s.metrics.inFlightBytes, -size)
+	if err != nil {
+		callback(fmt.Errorf("es bulk request failed, err:%s", err))
+		atomic.StoreInt32(&s.unhealthy, 1)
+		return dropped, err
+	}
+	atomic.StoreInt32(&s.unhealthy, 0)
+	done := 0
+	var dead []DeadItem
+	for i := range results {
+		f, p := &results[i], items[i]
+		if f.Succeeded() {
+			atomic.AddInt64(&s.metrics.indexed, 1)
+			done++
+			continue
+		}
+		switch s.classify(f, p.item.Op) {
+		case actionSucceed:
+			if f.Status == http.StatusConflict {
+				atomic.AddInt64(&s.metrics.duplicates, 1)
+			}
+			atomic.AddInt64(&s.metrics.indexed, 1)
+			done++
+		case actionDrop:
+			callback(fmt.Errorf("insert data %+v failed with status %d, drop, reason:%+v", p.item.Data, f.Status, errorReason(f)))
+			dead = append(dead, deadItem(p, errorReason(f), f.Status))
+			done++
+			dropped++
+		default:
+			callback(fmt.Errorf("insert data %+v failed with status %d, reason:%+v", p.item.Data, f.Status, errorReason(f)))
+			if p.retry >= s.retry {
+				callback(fmt.Errorf("data:%+v failed %d times, drop", p.item.Data, p.retry))
+				dead = append(dead, deadItem(p, errorReason(f), f.Status))
+				done++
+				dropped++
+			} else {
+				p.retry++
+				batch.items = append(batch.items, p)
+				batch.size += p.size
+				atomic.AddInt64(&s.metrics.retried, 1)
 			}
-		case <-s.done:
-			return
 		}
 	}
+	s.finish(done)
+	s.bury(dead, callback)
+	return dropped, nil
 }
 
+func deadItem(p *pending, reason string, status int) DeadItem {
+	return DeadItem{
+		EsData: p.item,
+		Reason: reason,
+		Status: status,
+		Retry:  p.retry,
+		Time:   time.Now(),
+	}
+}
 
+// bury 把放弃的数据写入死信
+func (s *BatES) bury(dead []DeadItem, callback ErrorCallback) {
+	if s.deadLetter == nil {
+		return
+	}
+	for _, item := range dead {
+		if err := s.deadLetter.Put(item); err != nil {
+			callback(fmt.Errorf("put data %+v to dead letter failed, err:%s", item.Data, err))
+		}
+	}
+}
 
+// errorReason bulk返回的单条错误原因
+func errorReason(item *BulkResult) string {
+	if item.Error == nil {
+		return ""
+	}
+	if item.Error.CausedBy != nil {
+		return fmt.Sprintf("%s, cause_by:%+v", item.Error.Reason, item.Error.CausedBy)
+	}
+	return item.Error.Reason
+}
+
+// drain 停止时读完通道中剩余的数据，交给worker提交
+func (s *BatES) drain(l *lane, batch *esBatch, callback ErrorCallback) {
+	for {
+		select {
+		case item := <-l.input:
+			s.add(batch, item, true)
+			if l.full(batch) {
+				s.dispatch(batch, callback)
+				batch = s.newBatch(l.priority)
+			}
+			continue
+		default:
+		}
+		break
+	}
+	if len(batch.items) != 0 {
+		s.dispatch(batch, callback)
+	}
+}
@@ -0,0 +1,307 @@
+package bes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dog-xyz/utils/bes/estest"
+)
+
+// memDeadLetter 保存在内存中的死信
+type memDeadLetter struct {
+	mu    sync.Mutex
+	items []DeadItem
+}
+
+func (m *memDeadLetter) Put(item DeadItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items = append(m.items, item)
+	return nil
+}
+
+func (m *memDeadLetter) Close() error {
+	return nil
+}
+
+// newTestES 使用estest.Server创建BatES，默认不按时间提交，重试等待1ms
+func newTestES(t *testing.T, srv *estest.Server, p BesArgs) *BatES {
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	p.Client = client
+	p.Snowflake, _ = NewSnowflake(1, 1)
+	if p.FlushInterval == 0 {
+		p.FlushInterval = time.Hour
+	}
+	if p.Backoff == nil {
+		p.Backoff = NewBackoff(time.Millisecond, time.Millisecond, p.Retry)
+	}
+	return NewBatES(p)
+}
+
+func stopES(t *testing.T, es *BatES) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dropped, _ := es.Stop(ctx)
+	return dropped
+}
+
+func submitN(t *testing.T, es *BatES, n int, id func(i int) string) {
+	for i := 0; i < n; i++ {
+		if err := es.Submit(context.Background(), EsData{Id: id(i), Index: "log", Data: map[string]int{"i": i}}); err != nil {
+			t.Fatalf("Failed to submit: %v", err)
+		}
+	}
+}
+
+func noId(int) string { return "" }
+
+func TestBatESBatchSize(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	es := newTestES(t, srv, BesArgs{BatchSize: 10})
+	submitN(t, es, 35, noId)
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if srv.Count() != 35 {
+		t.Errorf("Expected 35 documents, got %d", srv.Count())
+	}
+	if fmt.Sprint(srv.Batches()) != "[10 10 10 5]" {
+		t.Errorf("Unexpected batches %v", srv.Batches())
+	}
+}
+
+func TestBatESTickerFlush(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	es := newTestES(t, srv, BesArgs{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer stopES(t, es)
+	submitN(t, es, 5, noId)
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Count() < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if srv.Count() != 5 || len(srv.Batches()) != 1 {
+		t.Errorf("Expected 5 documents flushed by ticker in one batch, got %d in %v", srv.Count(), srv.Batches())
+	}
+}
+
+func TestBatESItemRetry(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.FailItem("429", http.StatusTooManyRequests, http.StatusTooManyRequests)
+	srv.FailItem("500", http.StatusInternalServerError)
+	es := newTestES(t, srv, BesArgs{BatchSize: 10, Retry: 3})
+	submitN(t, es, 2, func(i int) string { return []string{"429", "500"}[i] })
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if srv.Attempts("429") != 3 || srv.Attempts("500") != 2 || srv.Count() != 2 {
+		t.Errorf("Unexpected attempts 429:%d 500:%d, count %d", srv.Attempts("429"), srv.Attempts("500"), srv.Count())
+	}
+	if stats := es.Stats(); stats.Retried != 3 || stats.Indexed != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBatESDropAfterRetry(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.SetRule(func(item estest.Item) int {
+		switch item.Id {
+		case "always500":
+			return http.StatusInternalServerError
+		case "bad":
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	dl := &memDeadLetter{}
+	es := newTestES(t, srv, BesArgs{BatchSize: 10, Retry: 2, DeadLetter: dl})
+	submitN(t, es, 3, func(i int) string { return []string{"always500", "bad", "ok"}[i] })
+	if dropped := stopES(t, es); dropped != 2 {
+		t.Fatalf("Expected 2 dropped, got %d", dropped)
+	}
+	//第一次加上2次重试
+	if srv.Attempts("always500") != 3 || srv.Attempts("bad") != 1 || srv.Count() != 1 {
+		t.Errorf("Unexpected attempts always500:%d bad:%d, count %d", srv.Attempts("always500"), srv.Attempts("bad"), srv.Count())
+	}
+	if len(dl.items) != 2 {
+		t.Fatalf("Expected 2 dead letters, got %+v", dl.items)
+	}
+	for _, item := range dl.items {
+		if (item.Id == "bad" && item.Status != 400) || (item.Id == "always500" && (item.Status != 500 || item.Retry != 2)) {
+			t.Errorf("Unexpected dead letter %+v", item)
+		}
+	}
+}
+
+func TestBatESRequestFailures(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.FailRequest(http.StatusInternalServerError, http.StatusBadGateway)
+	es := newTestES(t, srv, BesArgs{BatchSize: 10, Retry: 3})
+	submitN(t, es, 10, noId)
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if stats := es.Stats(); srv.Count() != 10 || stats.BulkFailures != 2 || stats.BulkRequests != 3 {
+		t.Errorf("Expected 10 documents after 2 failed requests, got %d, %+v", srv.Count(), stats)
+	}
+
+	srv.FailRequest(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	dl := &memDeadLetter{}
+	es = newTestES(t, srv, BesArgs{BatchSize: 10, Retry: 2, DeadLetter: dl})
+	submitN(t, es, 4, noId)
+	if dropped := stopES(t, es); dropped != 4 || len(dl.items) != 4 {
+		t.Errorf("Expected 4 dropped to dead letter, got %d, %d", dropped, len(dl.items))
+	}
+}
+
+func TestBatESRequestTimeout(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.Delay(300 * time.Millisecond)
+	es := newTestES(t, srv, BesArgs{BatchSize: 10, Retry: 2, RequestTimeout: 50 * time.Millisecond})
+	submitN(t, es, 3, noId)
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if srv.Count() != 3 || es.Stats().BulkFailures != 1 {
+		t.Errorf("Expected 3 documents after a timed out request, got %d, %+v", srv.Count(), es.Stats())
+	}
+}
+
+func TestBatESShutdown(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.SetRule(func(item estest.Item) int {
+		//每条数据第一次都返回429
+		if item.Attempt == 1 {
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+	es := newTestES(t, srv, BesArgs{BatchSize: 7, Workers: 3, Retry: 3, ChannelSize: 1000})
+	submitN(t, es, 500, noId)
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if srv.Count() != 500 {
+		t.Errorf("Expected 500 documents after stop, got %d", srv.Count())
+	}
+	if stats := es.Stats(); stats.Pending != 0 || stats.Indexed != 500 {
+		t.Errorf("Unexpected stats after stop %+v", stats)
+	}
+	if err := es.Submit(context.Background(), EsData{Index: "log"}); err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Errorf("Expected second stop to be a no-op, got %d", dropped)
+	}
+}
+
+func TestBatESCallbackSerial(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	srv.SetRule(func(item estest.Item) int { return http.StatusBadRequest })
+	//回调不加锁，多个通道和worker同时报告错误时不能并发
+	var errs []error
+	es := newTestES(t, srv, BesArgs{BatchSize: 2, Workers: 4, FlushInterval: time.Millisecond,
+		Lanes:    []LaneArgs{{Patterns: []string{"audit"}}},
+		Callback: func(err error) { errs = append(errs, err) },
+	})
+	for i := 0; i < 50; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+		_ = es.Submit(context.Background(), EsData{Index: "audit", Op: OpUpdate})
+	}
+	if dropped := stopES(t, es); dropped != 100 {
+		t.Fatalf("Expected 100 dropped, got %d", dropped)
+	}
+	if len(errs) < 100 {
+		t.Errorf("Expected an error for each dropped document, got %d", len(errs))
+	}
+}
+
+func TestBatESStopDrain(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	//不会按条数或时间提交，只能在Stop时提交
+	es := newTestES(t, srv, BesArgs{BatchSize: 100})
+	submitN(t, es, 25, noId)
+	if err := es.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if srv.Count() != 25 || fmt.Sprint(srv.Batches()) != "[25]" {
+		t.Errorf("Expected 25 documents flushed by stop, got %d in %v", srv.Count(), srv.Batches())
+	}
+}
+
+func TestBatESStopDeadlineDropped(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: &hangSink{}, Snowflake: sf, BatchSize: 2, FlushInterval: time.Hour})
+	for i := 0; i < 5; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log"})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	dropped, err := es.Stop(ctx)
+	if dropped != 5 || err == nil {
+		t.Fatalf("Expected 5 dropped with error, got %d, %v", dropped, err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected stop to return after the deadline, took %v", time.Since(start))
+	}
+	if stats := es.Stats(); stats.Dropped != 5 || stats.Pending != 0 {
+		t.Errorf("Unexpected stats after stop %+v", stats)
+	}
+}
+
+func TestBatESBatchBytes(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	doc := EsData{Id: "x", Index: "log", Data: map[string]string{"msg": strings.Repeat("x", 50)}}
+	size, _ := requestSize(doc)
+	//每3条达到字节数阈值，剩下的1条由不到1秒的间隔提交
+	es := newTestES(t, srv, BesArgs{BatchSize: 100, BatchBytes: 3 * size, FlushInterval: 20 * time.Millisecond})
+	defer stopES(t, es)
+	for i := 0; i < 7; i++ {
+		doc.Id = fmt.Sprintf("%d", i)
+		if err := es.Submit(context.Background(), doc); err != nil {
+			t.Fatalf("Failed to submit: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.Count() < 7 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fmt.Sprint(srv.Batches()) != "[3 3 1]" {
+		t.Errorf("Expected batches split by bytes then flushed by interval, got %v", srv.Batches())
+	}
+}
+
+func TestBatESConcurrentWorkers(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	//第一个请求很慢，不影响其他worker提交
+	srv.Delay(time.Second)
+	es := newTestES(t, srv, BesArgs{BatchSize: 1, Workers: 2})
+	defer stopES(t, es)
+	submitN(t, es, 2, noId)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for srv.Count() < 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if srv.Count() != 1 {
+		t.Errorf("Expected second batch indexed while first request is slow, got %d", srv.Count())
+	}
+}
@@ -0,0 +1,79 @@
+package bes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultMaxDrift = time.Second
+	maxClockSeqBits = 8
+)
+
+// ErrClockBackwards 系统时钟回退超过允许的范围
+var ErrClockBackwards = errors.New("bes: clock moved backwards")
+
+// ClockPolicy 系统时钟回退时 Snowflake 的处理方式
+type ClockPolicy int
+
+const (
+	// ClockReject 返回 ErrClockBackwards，默认
+	ClockReject ClockPolicy = iota
+	// ClockWait 回退不超过MaxDrift时等待时钟追上
+	ClockWait
+	// ClockSequence 回退不超过MaxDrift时切换到下一个可用的时钟序列号，需要 Layout.ClockSeqBits
+	ClockSequence
+	// ClockMonotonic 使用启动时锚定的单调时钟，不受时钟回退影响，系统时钟向前调整时跟随
+	ClockMonotonic
+)
+
+// getCurrentTimestamp 获取当前时间戳（毫秒）
+func (s *Snowflake) getCurrentTimestamp() int64 {
+	wall := s.wall()
+	if s.clock != ClockMonotonic {
+		return wall
+	}
+	//time.Since 使用单调时钟
+	mono := s.anchorMillis + time.Since(s.anchor).Milliseconds()
+	if wall > mono {
+		s.anchor, s.anchorMillis = time.Now(), wall
+		return wall
+	}
+	return mono
+}
+
+// backwards 按 ClockPolicy 处理时钟回退，返回可以使用的时间戳
+func (s *Snowflake) backwards(now int64) (int64, error) {
+	drift := s.lastTimestamp - now
+	if drift <= s.maxDrift {
+		switch s.clock {
+		case ClockWait:
+			for now < s.lastTimestamp {
+				time.Sleep(time.Duration(s.lastTimestamp-now) * time.Millisecond)
+				now = s.getCurrentTimestamp()
+			}
+			return now, nil
+		case ClockSequence:
+			if s.nextClockSeq(now) {
+				return now, nil
+			}
+			return 0, fmt.Errorf("%w by %d milliseconds, no clock sequence available", ErrClockBackwards, drift)
+		}
+	}
+	return 0, fmt.Errorf("%w, refusing to generate ID for %d milliseconds", ErrClockBackwards, drift)
+}
+
+// nextClockSeq 切换到在now之后没有用过的时钟序列号，都用过时返回false
+func (s *Snowflake) nextClockSeq(now int64) bool {
+	s.clockUsed[s.clockSeq] = s.lastTimestamp
+	n := int64(len(s.clockUsed))
+	for i := int64(1); i < n; i++ {
+		c := (s.clockSeq + i) % n
+		if s.clockUsed[c] < now {
+			s.clockSeq = c
+			return true
+		}
+	}
+	return false
+}
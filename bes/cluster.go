@@ -0,0 +1,138 @@
+package bes
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultFailoverFailures = 3
+	defaultFailoverCooldown = 30 * time.Second
+)
+
+// FailoverArgs FailoverSink 的配置
+type FailoverArgs struct {
+	Primary   Sink
+	Secondary Sink
+	// Failures 主集群连续失败这么多次后切换到备集群，默认3
+	Failures int
+	// Cooldown 切换后经过这个时间重新尝试主集群，默认30秒
+	Cooldown time.Duration
+	Callback ErrorCallback
+}
+
+// FailoverSink 主集群连续请求失败时切换到备集群，冷却后再尝试主集群
+// 大部分数据被拒绝（429、5xx）的请求也算失败
+type FailoverSink struct {
+	mu        sync.Mutex
+	primary   Sink
+	secondary Sink
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	// failedAt 切换到备集群的时间，为零时使用主集群
+	failedAt time.Time
+	callback ErrorCallback
+}
+
+func NewFailoverSink(p FailoverArgs) *FailoverSink {
+	if p.Primary == nil || p.Secondary == nil {
+		panic("primary or secondary sink is nil")
+	}
+	if p.Failures <= 0 {
+		p.Failures = defaultFailoverFailures
+	}
+	if p.Cooldown <= 0 {
+		p.Cooldown = defaultFailoverCooldown
+	}
+	if p.Callback == nil {
+		p.Callback = func(err error) {}
+	}
+	return &FailoverSink{
+		primary:   p.Primary,
+		secondary: p.Secondary,
+		threshold: p.Failures,
+		cooldown:  p.Cooldown,
+		callback:  p.Callback,
+	}
+}
+
+func (f *FailoverSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	sink, primary := f.pick()
+	results, err := sink.Bulk(ctx, items)
+	if primary {
+		f.report(results, err)
+	}
+	return results, err
+}
+
+// Request 实现 Requester，发送到当前使用的集群
+func (f *FailoverSink) Request(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	sink, _ := f.pick()
+	r, ok := sink.(Requester)
+	if !ok {
+		return 0, nil, fmt.Errorf("sink %T does not support requests", sink)
+	}
+	return r.Request(ctx, method, path, body)
+}
+
+// Failover 当前是否使用备集群
+func (f *FailoverSink) Failover() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.failedAt.IsZero()
+}
+
+// pick 返回这次请求使用的集群，冷却结束后重新尝试主集群
+func (f *FailoverSink) pick() (Sink, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failedAt.IsZero() {
+		return f.primary, true
+	}
+	if time.Since(f.failedAt) >= f.cooldown {
+		//再失败一次就切回备集群
+		f.failedAt = time.Time{}
+		f.failures = f.threshold - 1
+		return f.primary, true
+	}
+	return f.secondary, false
+}
+
+// report 记录主集群的请求结果，请求成功但一半以上的数据返回可重试的状态码（如429、503）也算失败
+func (f *FailoverSink) report(results []BulkResult, err error) {
+	if err == nil {
+		retryable := 0
+		for i := range results {
+			if retryableStatus(results[i].Status) {
+				retryable++
+			}
+		}
+		if retryable*2 > len(results) {
+			err = fmt.Errorf("%d of %d items rejected", retryable, len(results))
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		f.failures = 0
+		return
+	}
+	f.failures++
+	if f.failures >= f.threshold && f.failedAt.IsZero() {
+		f.failedAt = time.Now()
+		f.callback(fmt.Errorf("primary cluster failed %d times, fail over to secondary, err:%s", f.failures, err))
+	}
+}
+
+// mirrorItem 把数据提交到镜像的BatES，id和主集群一致
+// 不阻塞主集群，镜像无法接收时放弃并计数
+func (s *BatES) mirrorItem(item EsData) {
+	if !s.mirror.TrySubmit(item) {
+		atomic.AddInt64(&s.metrics.mirrorDropped, 1)
+		s.Callback(fmt.Errorf("mirror queue is full or stopped, drop data %+v", item.Data))
+	}
+}
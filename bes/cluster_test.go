@@ -0,0 +1,199 @@
+package bes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dog-xyz/utils/bes/estest"
+)
+
+func TestFailoverSink(t *testing.T) {
+	primary, secondary := &failSink{}, &recordSink{}
+	var errs []error
+	f := NewFailoverSink(FailoverArgs{
+		Primary:   primary,
+		Secondary: secondary,
+		Failures:  2,
+		Cooldown:  50 * time.Millisecond,
+		Callback:  func(err error) { errs = append(errs, err) },
+	})
+	items := []EsData{{Id: "1", Index: "log"}}
+	for i := 0; i < 2; i++ {
+		if _, err := f.Bulk(context.Background(), items); err == nil {
+			t.Fatal("Expected primary error")
+		}
+	}
+	if !f.Failover() || len(errs) != 1 {
+		t.Fatalf("Expected failover after 2 failures, got %v, %v", f.Failover(), errs)
+	}
+	if _, err := f.Bulk(context.Background(), items); err != nil || len(secondary.sizes) != 1 {
+		t.Fatalf("Expected request sent to secondary, err: %v", err)
+	}
+
+	//冷却后重新尝试主集群，失败一次就切回备集群
+	time.Sleep(60 * time.Millisecond)
+	if _, err := f.Bulk(context.Background(), items); err == nil || primary.calls != 3 {
+		t.Fatalf("Expected primary retried after cooldown, calls %d", primary.calls)
+	}
+	if !f.Failover() || len(errs) != 2 {
+		t.Errorf("Expected failover again, got %v, %v", f.Failover(), errs)
+	}
+}
+
+func TestFailoverItemErrors(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	f := NewFailoverSink(FailoverArgs{
+		Primary:   NewOlivereSink(client, nil),
+		Secondary: &recordSink{},
+		Failures:  2,
+	})
+	items := []EsData{{Id: "a", Index: "log"}, {Id: "b", Index: "log"}, {Id: "c", Index: "log"}}
+	//少数数据被拒绝不算失败
+	srv.FailItem("a", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	for i := 0; i < 2; i++ {
+		if _, err := f.Bulk(context.Background(), items); err != nil {
+			t.Fatalf("Failed to bulk: %v", err)
+		}
+	}
+	if f.Failover() {
+		t.Fatal("Expected no failover when few items rejected")
+	}
+	//请求返回200但所有数据都是503
+	srv.SetRule(func(item estest.Item) int { return http.StatusServiceUnavailable })
+	for i := 0; i < 2; i++ {
+		if _, err := f.Bulk(context.Background(), items); err != nil {
+			t.Fatalf("Failed to bulk: %v", err)
+		}
+	}
+	if !f.Failover() {
+		t.Error("Expected failover when primary rejects every item")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	primary, secondary := &createSink{seen: map[string]bool{}}, &createSink{seen: map[string]bool{}}
+	mirror := NewBatES(BesArgs{Sink: secondary, Snowflake: sf, FlushInterval: 5 * time.Millisecond})
+	es := NewBatES(BesArgs{Sink: primary, Snowflake: sf, Mirror: mirror, FlushInterval: 5 * time.Millisecond})
+	for i := 0; i < 25; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := es.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if _, err := mirror.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop mirror: %v", err)
+	}
+
+	ids := func(c *createSink) []string {
+		var ids []string
+		for id := range c.seen {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	p, m := ids(primary), ids(secondary)
+	if len(p) != 25 || len(m) != 25 {
+		t.Fatalf("Expected 25 documents in both clusters, got %d and %d", len(p), len(m))
+	}
+	for i := range p {
+		if p[i] != m[i] {
+			t.Fatalf("Expected same ids in both clusters, got %s and %s", p[i], m[i])
+		}
+	}
+}
+
+func TestMirrorDoesNotBlock(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	primary := &createSink{seen: map[string]bool{}}
+	mirror := NewBatES(BesArgs{Sink: &hangSink{}, Snowflake: sf, ChannelSize: 1, BatchSize: 1, FlushInterval: 5 * time.Millisecond})
+	es := NewBatES(BesArgs{Sink: primary, Snowflake: sf, Mirror: mirror, FlushInterval: 5 * time.Millisecond})
+	for i := 0; i < 50; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := es.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if stats := es.Stats(); len(primary.seen) != 50 || stats.MirrorDropped == 0 {
+		t.Errorf("Expected all documents in primary and some mirror drops, got %d, %+v", len(primary.seen), stats)
+	}
+	mctx, mcancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer mcancel()
+	_, _ = mirror.Stop(mctx)
+}
+
+// flakySink 前fails次请求失败，之后记录每个id写入的次数
+type flakySink struct {
+	mu     sync.Mutex
+	fails  int
+	counts map[string]int
+}
+
+func (f *flakySink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fails > 0 {
+		f.fails--
+		return nil, errors.New("connection refused")
+	}
+	results := make([]BulkResult, len(items))
+	for i, item := range items {
+		f.counts[item.Id]++
+		results[i] = BulkResult{Index: item.Index, Id: item.Id, Status: http.StatusCreated}
+	}
+	return results, nil
+}
+
+func TestMirrorSpillReplay(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	defer q.Close()
+	sf, _ := NewSnowflake(1, 1)
+	secondary := &flakySink{counts: map[string]int{}}
+	mirror := NewBatES(BesArgs{Sink: secondary, Snowflake: sf, FlushInterval: 5 * time.Millisecond})
+	es := NewBatES(BesArgs{
+		Sink:          &flakySink{fails: 10, counts: map[string]int{}},
+		Snowflake:     sf,
+		Mirror:        mirror,
+		Spill:         q,
+		BatchSize:     5,
+		Backoff:       NewBackoff(time.Millisecond, time.Millisecond, 0),
+		FlushInterval: 5 * time.Millisecond,
+	})
+	for i := 0; i < 20; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for (!q.Empty() || es.Stats().Indexed < 20) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = es.Stop(ctx)
+	_, _ = mirror.Stop(ctx)
+	if accepted := mirror.SubmitStats().Accepted; accepted != 20 || len(secondary.counts) != 20 {
+		t.Fatalf("Expected 20 documents mirrored once, got %d submissions, %d ids", accepted, len(secondary.counts))
+	}
+	for id, n := range secondary.counts {
+		if n != 1 {
+			t.Errorf("Expected id %s written once to mirror, got %d", id, n)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package bes
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+const (
+	defaultDeadLetterSize  = 100 * 1024 * 1024
+	defaultDeadLetterFiles = 5
+)
+
+// DeadItem 放弃写入的数据和失败原因
+type DeadItem struct {
+	EsData
+	Reason string    `json:"reason"`
+	Status int       `json:"status"`
+	Retry  int       `json:"retry"`
+	Time   time.Time `json:"time"`
+}
+
+// DeadLetter 接收 BatES 放弃的数据
+type DeadLetter interface {
+	Put(item DeadItem) error
+	Close() error
+}
+
+// FileDeadLetter 按大小滚动的 json lines 文件
+type FileDeadLetter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// NewFileDeadLetter 写入path，超过maxSize字节后滚动为path.1 ... path.maxFiles
+func NewFileDeadLetter(path string, maxSize int64, maxFiles int) (*FileDeadLetter, error) {
+	if maxSize <= 0 {
+		maxSize = defaultDeadLetterSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultDeadLetterFiles
+	}
+	dl := &FileDeadLetter{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := dl.open(); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+func (dl *FileDeadLetter) open() error {
+	f, err := os.OpenFile(dl.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("open dead letter file %s failed, err:%w", dl.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	dl.file = f
+	dl.size = info.Size()
+	return nil
+}
+
+// rotate path.n-1 -> path.n, ..., path -> path.1
+func (dl *FileDeadLetter) rotate() error {
+	if err := dl.file.Close(); err != nil {
+		return err
+	}
+	_ = os.Remove(fmt.Sprintf("%s.%d", dl.path, dl.maxFiles))
+	for i := dl.maxFiles - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", dl.path, i), fmt.Sprintf("%s.%d", dl.path, i+1))
+	}
+	if err := os.Rename(dl.path, dl.path+".1"); err != nil {
+		return err
+	}
+	return dl.open()
+}
+
+func (dl *FileDeadLetter) Put(item DeadItem) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("marshal dead letter %+v failed, err:%w", item.Data, err)
+	}
+	line = append(line, '\n')
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.size > 0 && dl.size+int64(len(line)) > dl.maxSize {
+		if err = dl.rotate(); err != nil {
+			return fmt.Errorf("rotate dead letter file failed, err:%w", err)
+		}
+	}
+	n, err := dl.file.Write(line)
+	dl.size += int64(n)
+	return err
+}
+
+func (dl *FileDeadLetter) Close() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.file.Close()
+}
+
+// EsDeadLetter 写入另一个es索引，data保存为json字符串，避免和原索引一样的mapping错误
+type EsDeadLetter struct {
+	client  *elastic.Client
+	index   string
+	timeout time.Duration
+}
+
+func NewEsDeadLetter(client *elastic.Client, index string, timeout time.Duration) *EsDeadLetter {
+	return &EsDeadLetter{
+		client:  client,
+		index:   index,
+		timeout: timeout,
+	}
+}
+
+func (dl *EsDeadLetter) Put(item DeadItem) error {
+	data, err := json.Marshal(item.Data)
+	if err != nil {
+		return fmt.Errorf("marshal dead letter %+v failed, err:%w", item.Data, err)
+	}
+	ctx := context.Background()
+	if dl.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dl.timeout)
+		defer cancel()
+	}
+	_, err = dl.client.Index().Index(dl.index).BodyJson(map[string]interface{}{
+		"id":     item.Id,
+		"index":  item.Index,
+		"op":     item.Op,
+		"data":   string(data),
+		"reason": item.Reason,
+		"status": item.Status,
+		"retry":  item.Retry,
+		"time":   item.Time,
+	}).Do(ctx)
+	return err
+}
+
+func (dl *EsDeadLetter) Close() error {
+	return nil
+}
+
+// ReplayDeadLetter 读取 FileDeadLetter 的文件，重新写入es，返回写入的条数
+func ReplayDeadLetter(ctx context.Context, path string, es *BatES) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		var item rawEsData
+		if err = json.Unmarshal(sc.Bytes(), &item); err != nil {
+			return n, fmt.Errorf("parse dead letter line %d failed, err:%w", n+1, err)
+		}
+		if err = es.Submit(ctx, item.esData()); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, sc.Err()
+}
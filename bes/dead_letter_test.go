@@ -0,0 +1,156 @@
+package bes
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dog-xyz/utils/bes/estest"
+)
+
+func TestFileDeadLetterRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.log")
+	dl, err := NewFileDeadLetter(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed to create dead letter: %v", err)
+	}
+	defer dl.Close()
+
+	for i := 0; i < 10; i++ {
+		err = dl.Put(DeadItem{
+			EsData: EsData{
+				Id:    "id",
+				Index: "log_2025-01-01",
+				Data:  map[string]int{"i": i},
+			},
+			Reason: "mapper_parsing_exception",
+			Status: 400,
+			Time:   time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Failed to put dead letter: %v", err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatalf("Expected rotated file %s: %v", p, err)
+		}
+		if info.Size() > 200 {
+			t.Errorf("File %s exceeds max size: %d", p, info.Size())
+		}
+	}
+	if _, err = os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 rotated files")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open dead letter: %v", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var item DeadItem
+		if err = json.Unmarshal(sc.Bytes(), &item); err != nil {
+			t.Fatalf("Failed to parse dead letter line: %v", err)
+		}
+		if item.Status != 400 || item.Index != "log_2025-01-01" {
+			t.Errorf("Unexpected dead letter item: %+v", item)
+		}
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	//第一次写入时除了ok都返回400
+	srv.SetRule(func(item estest.Item) int {
+		if item.Attempt == 1 && item.Id != "ok" {
+			return http.StatusBadRequest
+		}
+		return 0
+	})
+	path := filepath.Join(t.TempDir(), "dead.log")
+	dl, err := NewFileDeadLetter(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Failed to create dead letter: %v", err)
+	}
+	es := newTestES(t, srv, BesArgs{BatchSize: 10, DeadLetter: dl})
+	for _, d := range []EsData{
+		{Id: "ok", Index: "log", Data: map[string]int{"i": 0}},
+		{Id: "a", Index: "log", Data: map[string]int{"i": 1}},
+		{Id: "b", Index: "log", Op: OpCreate, Data: map[string]int{"i": 2}},
+	} {
+		if err = es.Submit(context.Background(), d); err != nil {
+			t.Fatalf("Failed to submit: %v", err)
+		}
+	}
+	if dropped := stopES(t, es); dropped != 2 {
+		t.Fatalf("Expected 2 dropped, got %d", dropped)
+	}
+	if err = dl.Close(); err != nil {
+		t.Fatalf("Failed to close dead letter: %v", err)
+	}
+
+	es = newTestES(t, srv, BesArgs{BatchSize: 10})
+	n, err := ReplayDeadLetter(context.Background(), path, es)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 replayed, got %d, err: %v", n, err)
+	}
+	if dropped := stopES(t, es); dropped != 0 {
+		t.Fatalf("Expected nothing dropped on replay, got %d", dropped)
+	}
+	docs := srv.Docs("log")
+	if len(docs) != 3 || string(docs["a"]) != `{"i":1}` || string(docs["b"]) != `{"i":2}` {
+		t.Errorf("Unexpected documents after replay %v", docs)
+	}
+	if srv.Attempts("a") != 2 || srv.Attempts("b") != 2 || srv.Attempts("ok") != 1 {
+		t.Errorf("Expected only dead letters replayed, got a:%d b:%d ok:%d", srv.Attempts("a"), srv.Attempts("b"), srv.Attempts("ok"))
+	}
+}
+
+func TestEsDeadLetter(t *testing.T) {
+	srv := estest.NewServer()
+	defer srv.Close()
+	client, err := srv.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	dl := NewEsDeadLetter(client, "dead", time.Second)
+	err = dl.Put(DeadItem{
+		EsData: EsData{Id: "a", Index: "log", Data: map[string]int{"i": 1}},
+		Reason: "mapper_parsing_exception",
+		Status: 400,
+		Time:   time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to put dead letter: %v", err)
+	}
+	docs := srv.Docs("dead")
+	if len(docs) != 1 {
+		t.Fatalf("Expected 1 dead letter document, got %v", docs)
+	}
+	for _, doc := range docs {
+		var item struct {
+			Id     string `json:"id"`
+			Index  string `json:"index"`
+			Data   string `json:"data"`
+			Reason string `json:"reason"`
+			Status int    `json:"status"`
+		}
+		if err = json.Unmarshal(doc, &item); err != nil {
+			t.Fatalf("Failed to parse dead letter document: %v", err)
+		}
+		//data保存为json字符串
+		if item.Id != "a" || item.Index != "log" || item.Data != `{"i":1}` || item.Status != 400 || item.Reason != "mapper_parsing_exception" {
+			t.Errorf("Unexpected dead letter document %+v", item)
+		}
+	}
+}
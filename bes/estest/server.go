@@ -0,0 +1,294 @@
+// Package estest 提供测试用的内存es，支持 olivere/elastic 使用的 _bulk 和单条写入的 _doc 接口
+package estest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Item bulk请求中的一条数据
+type Item struct {
+	Op     string
+	Index  string
+	Id     string
+	Source json.RawMessage
+	// Attempt 同一个id第几次出现，从1开始
+	Attempt int
+}
+
+// Rule 返回这条数据的状态码，返回0时按正常写入处理
+type Rule func(item Item) int
+
+// Server 内存中的es，只实现 _bulk 和 _doc
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	docs     map[string]map[string]json.RawMessage
+	attempts map[string]int
+	batches  []int
+	// itemStatus 每个id依次返回的状态码
+	itemStatus map[string][]int
+	// requestStatus 依次返回的整个请求的状态码
+	requestStatus []int
+	delays        []time.Duration
+	rule          Rule
+	// autoID 单条写入没有id时生成id
+	autoID int
+}
+
+// NewServer 启动服务，使用完需要Close
+func NewServer() *Server {
+	s := &Server{
+		docs:       make(map[string]map[string]json.RawMessage),
+		attempts:   make(map[string]int),
+		itemStatus: make(map[string][]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Client 返回连接这个服务的 olivere 客户端
+func (s *Server) Client() (*elastic.Client, error) {
+	return elastic.NewClient(elastic.SetURL(s.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+}
+
+// FailItem id依次返回statuses中的状态码，用完后正常写入
+func (s *Server) FailItem(id string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.itemStatus[id] = append(s.itemStatus[id], statuses...)
+}
+
+// FailRequest 之后的请求依次返回statuses中的状态码，用完后正常处理
+func (s *Server) FailRequest(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requestStatus = append(s.requestStatus, statuses...)
+}
+
+// Delay 之后的请求依次等待delays中的时间再返回，用于模拟超时
+func (s *Server) Delay(delays ...time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays = append(s.delays, delays...)
+}
+
+// SetRule 设置每条数据的状态码，优先于FailItem
+func (s *Server) SetRule(rule Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rule = rule
+}
+
+// Docs 返回索引中写入成功的数据
+func (s *Server) Docs(index string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	docs := make(map[string]json.RawMessage, len(s.docs[index]))
+	for id, doc := range s.docs[index] {
+		docs[id] = doc
+	}
+	return docs
+}
+
+// Count 返回所有索引中的数据条数
+func (s *Server) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, docs := range s.docs {
+		n += len(docs)
+	}
+	return n
+}
+
+// Attempts 返回id出现在bulk请求中的次数
+func (s *Server) Attempts(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[id]
+}
+
+// Batches 返回每次bulk请求的条数，不包含整个请求失败的请求
+func (s *Server) Batches() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if (r.Method == http.MethodPost || r.Method == http.MethodPut) && strings.Contains(r.URL.Path, "/_doc") {
+		s.handleDoc(w, r)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		http.Error(w, `{"error":{"type":"illegal_argument_exception","reason":"unsupported"},"status":400}`, http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	var delay time.Duration
+	if len(s.delays) > 0 {
+		delay, s.delays = s.delays[0], s.delays[1:]
+	}
+	status := 0
+	if len(s.requestStatus) > 0 {
+		status, s.requestStatus = s.requestStatus[0], s.requestStatus[1:]
+	}
+	s.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 && status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"error":{"type":"test_exception","reason":"status %d"},"status":%d}`, status, status)
+		return
+	}
+
+	items, err := parseBulk(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results := make([]string, 0, len(items))
+	hasErrors := false
+	s.mu.Lock()
+	s.batches = append(s.batches, len(items))
+	for _, item := range items {
+		s.attempts[item.Id]++
+		item.Attempt = s.attempts[item.Id]
+		code := s.status(item)
+		result := fmt.Sprintf(`"_index":%q,"_id":%q,"status":%d`, item.Index, item.Id, code)
+		if code >= 300 {
+			hasErrors = true
+			result += fmt.Sprintf(`,"error":{"type":"test_exception","reason":"status %d"}`, code)
+		} else {
+			result += fmt.Sprintf(`,"result":%q`, s.apply(item))
+		}
+		results = append(results, fmt.Sprintf(`{%q:{%s}}`, item.Op, result))
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, hasErrors, strings.Join(results, ","))
+}
+
+// handleDoc 单条写入 /{index}/_doc[/{id}]，不经过Rule和失败设置
+func (s *Server) handleDoc(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	body, err := io.ReadAll(r.Body)
+	if err != nil || len(parts) < 2 || parts[1] != "_doc" {
+		http.Error(w, r.URL.Path, http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	item := Item{Op: "index", Index: parts[0], Source: body}
+	if len(parts) > 2 {
+		item.Id = parts[2]
+	} else {
+		s.autoID++
+		item.Id = fmt.Sprintf("auto-%d", s.autoID)
+	}
+	s.attempts[item.Id]++
+	result := s.apply(item)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"_index":%q,"_id":%q,"_version":1,"result":%q}`, item.Index, item.Id, result)
+}
+
+// status 返回这条数据的状态码，需要持有锁
+func (s *Server) status(item Item) int {
+	if s.rule != nil {
+		if code := s.rule(item); code != 0 {
+			return code
+		}
+	}
+	if statuses := s.itemStatus[item.Id]; len(statuses) > 0 {
+		s.itemStatus[item.Id] = statuses[1:]
+		return statuses[0]
+	}
+	docs := s.docs[item.Index]
+	switch item.Op {
+	case "create":
+		if _, ok := docs[item.Id]; ok {
+			return http.StatusConflict
+		}
+		return http.StatusCreated
+	case "update", "delete":
+		if _, ok := docs[item.Id]; !ok {
+			return http.StatusNotFound
+		}
+		return http.StatusOK
+	}
+	return http.StatusCreated
+}
+
+// apply 写入数据，返回result，需要持有锁
+func (s *Server) apply(item Item) string {
+	docs := s.docs[item.Index]
+	if docs == nil {
+		docs = make(map[string]json.RawMessage)
+		s.docs[item.Index] = docs
+	}
+	switch item.Op {
+	case "delete":
+		delete(docs, item.Id)
+		return "deleted"
+	case "update":
+		var body struct {
+			Doc json.RawMessage `json:"doc"`
+		}
+		_ = json.Unmarshal(item.Source, &body)
+		if body.Doc != nil {
+			docs[item.Id] = body.Doc
+		}
+		return "updated"
+	}
+	docs[item.Id] = item.Source
+	return "created"
+}
+
+// parseBulk 解析NDJSON请求体
+func parseBulk(r *http.Request) ([]Item, error) {
+	var items []Item
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil {
+			return nil, fmt.Errorf("parse bulk action %s failed, err:%w", line, err)
+		}
+		for op, meta := range action {
+			item := Item{Op: op, Index: meta.Index, Id: meta.Id}
+			if op != "delete" {
+				if !sc.Scan() {
+					return nil, fmt.Errorf("missing source for %s %s", op, meta.Id)
+				}
+				item.Source = append(json.RawMessage(nil), sc.Bytes()...)
+			}
+			items = append(items, item)
+		}
+	}
+	return items, sc.Err()
+}
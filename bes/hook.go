@@ -0,0 +1,56 @@
+package bes
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BulkInfo 一次bulk请求的信息
+type BulkInfo struct {
+	// Docs Bytes 请求中的条数和估计字节数，没有设置BatchBytes、MaxBulkBytes、MaxDocBytes时Bytes为0
+	Docs  int
+	Bytes int64
+	// Failed 返回失败的条数，请求失败时为0
+	Failed int
+	// Duration 请求耗时，BeforeBulk中为0
+	Duration time.Duration
+}
+
+// BulkHook 在每次bulk请求前后调用，可以用来创建trace span或记录日志
+type BulkHook interface {
+	// BeforeBulk 返回的ctx用于这次请求和AfterBulk
+	BeforeBulk(ctx context.Context, info BulkInfo) context.Context
+	AfterBulk(ctx context.Context, info BulkInfo, err error)
+}
+
+// doBulk 带超时和hook执行一次bulk请求
+func (s *BatES) doBulk(ctx context.Context, docs []EsData, size int64) ([]BulkResult, error) {
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	info := BulkInfo{Docs: len(docs), Bytes: size}
+	if s.hook != nil {
+		ctx = s.hook.BeforeBulk(ctx, info)
+	}
+	start := time.Now()
+	results, err := s.sink.Bulk(ctx, docs)
+	if err == nil && len(results) != len(docs) {
+		err = fmt.Errorf("bulk returned %d results for %d documents", len(results), len(docs))
+	}
+	info.Duration = time.Since(start)
+	s.metrics.observe(info.Duration, err)
+	if s.hook != nil {
+		if err == nil {
+			for i := range results {
+				if !results[i].Succeeded() {
+					info.Failed++
+				}
+			}
+		}
+		s.hook.AfterBulk(ctx, info, err)
+	}
+	return results, err
+}
@@ -0,0 +1,94 @@
+package bes
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hookKey struct{}
+
+// hangSink 一直等到ctx结束
+type hangSink struct {
+	mu     sync.Mutex
+	values []interface{}
+}
+
+func (h *hangSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	h.mu.Lock()
+	h.values = append(h.values, ctx.Value(hookKey{}))
+	h.mu.Unlock()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type recordHook struct {
+	mu     sync.Mutex
+	before []BulkInfo
+	after  []BulkInfo
+	errs   []error
+}
+
+func (r *recordHook) BeforeBulk(ctx context.Context, info BulkInfo) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.before = append(r.before, info)
+	return context.WithValue(ctx, hookKey{}, "span")
+}
+
+func (r *recordHook) AfterBulk(ctx context.Context, info BulkInfo, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.after = append(r.after, info)
+	r.errs = append(r.errs, err)
+}
+
+func TestRequestTimeoutAndHook(t *testing.T) {
+	sink, hook := &hangSink{}, &recordHook{}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, Hook: hook, MaxBulkBytes: 1 << 20, RequestTimeout: 20 * time.Millisecond, FlushInterval: 5 * time.Millisecond})
+	_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"a": 1}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dropped, _ := es.Stop(ctx); dropped != 1 {
+		t.Fatalf("Expected 1 dropped, got %d", dropped)
+	}
+	if len(hook.before) != 1 || hook.before[0].Docs != 1 || hook.before[0].Bytes == 0 {
+		t.Errorf("Unexpected BeforeBulk calls %+v", hook.before)
+	}
+	if len(hook.after) != 1 || hook.after[0].Duration < 20*time.Millisecond || !errors.Is(hook.errs[0], context.DeadlineExceeded) {
+		t.Errorf("Unexpected AfterBulk calls %+v, %v", hook.after, hook.errs)
+	}
+	if len(sink.values) != 1 || sink.values[0] != "span" {
+		t.Errorf("Expected hook context passed to sink, got %v", sink.values)
+	}
+}
+
+func TestParentContextCancel(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{
+		Sink:          &hangSink{},
+		Snowflake:     sf,
+		Context:       parent,
+		Retry:         5,
+		Backoff:       NewBackoff(time.Millisecond, time.Millisecond, 5),
+		FlushInterval: 5 * time.Millisecond,
+	})
+	_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"a": 1}})
+	time.Sleep(20 * time.Millisecond)
+	cancelParent()
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = es.Stop(ctx)
+	if dropped := es.Stats().Dropped; dropped != 1 {
+		t.Errorf("Expected 1 dropped, got %d", dropped)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected stop soon after parent cancel, took %s", time.Since(start))
+	}
+}
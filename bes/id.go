@@ -0,0 +1,59 @@
+package bes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// IDFunc 根据数据生成稳定的id，同一条数据重复提交时得到相同的id
+// 返回空字符串时使用Snowflake生成id
+type IDFunc func(d EsData) (string, error)
+
+// HashID 使用data中fields字段的值计算sha256作为id，没有fields时使用整个data
+// data会先序列化为json，map的key按顺序输出，结构体按字段顺序输出
+func HashID(fields ...string) IDFunc {
+	return func(d EsData) (string, error) {
+		b, err := json.Marshal(d.Data)
+		if err != nil {
+			return "", fmt.Errorf("marshal data %+v failed, err:%w", d.Data, err)
+		}
+		h := sha256.New()
+		if len(fields) == 0 {
+			h.Write(b)
+			return hex.EncodeToString(h.Sum(nil)[:16]), nil
+		}
+		var doc map[string]json.RawMessage
+		if err = json.Unmarshal(b, &doc); err != nil {
+			return "", fmt.Errorf("hash id needs a json object, err:%w", err)
+		}
+		for _, f := range fields {
+			v, ok := doc[f]
+			if !ok {
+				return "", fmt.Errorf("hash id field %s not found", f)
+			}
+			h.Write([]byte(f))
+			h.Write([]byte{0})
+			h.Write(v)
+			h.Write([]byte{0})
+		}
+		return hex.EncodeToString(h.Sum(nil)[:16]), nil
+	}
+}
+
+// newID 没有指定id时生成id，优先使用IDFunc，生成失败时数据按无效数据放弃并写入死信
+func (s *BatES) newID(item EsData) (string, error) {
+	if s.idFunc != nil {
+		id, err := s.idFunc(item)
+		if err != nil || id != "" {
+			return id, err
+		}
+	}
+	id, err := s.Snowflake.NextID()
+	if err != nil {
+		return "", fmt.Errorf("generate snowflake id failed, err:%w", err)
+	}
+	return strconv.FormatInt(id, 10), nil
+}
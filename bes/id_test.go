@@ -0,0 +1,104 @@
+package bes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHashID(t *testing.T) {
+	all := HashID()
+	a, _ := all(EsData{Data: map[string]interface{}{"user": "a", "seq": 1}})
+	b, _ := all(EsData{Data: map[string]interface{}{"seq": 1, "user": "a"}})
+	c, _ := all(EsData{Data: map[string]interface{}{"user": "a", "seq": 2}})
+	if a == "" || a != b || a == c {
+		t.Errorf("Expected stable id for same content, got %s %s %s", a, b, c)
+	}
+
+	byUser := HashID("user")
+	a, _ = byUser(EsData{Data: map[string]interface{}{"user": "a", "seq": 1}})
+	c, _ = byUser(EsData{Data: map[string]interface{}{"user": "a", "seq": 2}})
+	if a != c {
+		t.Errorf("Expected same id for same field, got %s %s", a, c)
+	}
+	if _, err := byUser(EsData{Data: map[string]interface{}{"seq": 1}}); err == nil {
+		t.Error("Expected error for missing field")
+	}
+}
+
+// createSink create已存在的id时返回409
+type createSink struct {
+	mu   sync.Mutex
+	seen map[string]bool
+	ops  []OpType
+}
+
+func (c *createSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make([]BulkResult, len(items))
+	for i, item := range items {
+		c.ops = append(c.ops, item.Op)
+		results[i] = BulkResult{Index: item.Index, Id: item.Id, Status: http.StatusCreated}
+		if c.seen[item.Id] {
+			results[i].Status = http.StatusConflict
+			results[i].Error = &BulkError{Type: "version_conflict_engine_exception"}
+		}
+		c.seen[item.Id] = true
+	}
+	return results, nil
+}
+
+func TestDedup(t *testing.T) {
+	sink := &createSink{seen: map[string]bool{}}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, IDFunc: HashID("key"), Dedup: true, FlushInterval: 10 * time.Millisecond})
+	for _, key := range []string{"a", "b", "a"} {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]string{"key": key}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dropped, err := es.Stop(ctx); dropped != 0 || err != nil {
+		t.Fatalf("Expected nothing dropped, got %d, %v", dropped, err)
+	}
+	stats := es.Stats()
+	if stats.Indexed != 3 || stats.Duplicates != 1 {
+		t.Errorf("Expected 3 indexed with 1 duplicate, got %+v", stats)
+	}
+	for _, op := range sink.ops {
+		if op != OpCreate {
+			t.Errorf("Expected create in dedup mode, got %q", op)
+		}
+	}
+}
+
+func TestSnowflakeErrorDeadLetter(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	base := time.Now().UnixMilli()
+	calls := 0
+	sf.wall = func() int64 {
+		//第二次时钟回退
+		calls++
+		if calls == 2 {
+			return base - 10
+		}
+		return base
+	}
+	dl := &memDeadLetter{}
+	sink := &recordSink{}
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, DeadLetter: dl, FlushInterval: 5 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dropped, _ := es.Stop(ctx); dropped != 1 {
+		t.Fatalf("Expected 1 dropped, got %d", dropped)
+	}
+	if len(dl.items) != 1 || dl.items[0].Id != "" || !strings.Contains(dl.items[0].Reason, "clock moved backwards") {
+		t.Errorf("Unexpected dead letters %+v", dl.items)
+	}
+}
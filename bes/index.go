@@ -0,0 +1,151 @@
+package bes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// IndexPeriod 按时间滚动索引的周期
+type IndexPeriod int
+
+const (
+	// PeriodDaily 按天，prefix_2006-01-02
+	PeriodDaily IndexPeriod = iota
+	// PeriodHourly 按小时，prefix_2006-01-02-15
+	PeriodHourly
+	// PeriodWeekly 按ISO周，prefix_2006-w01
+	PeriodWeekly
+)
+
+// IndexFormatter 生成按时间滚动的索引名
+type IndexFormatter struct {
+	prefix string
+	period IndexPeriod
+	loc    *time.Location
+}
+
+// NewIndexFormatter loc为空时使用UTC
+func NewIndexFormatter(prefix string, period IndexPeriod, loc *time.Location) *IndexFormatter {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &IndexFormatter{
+		prefix: prefix,
+		period: period,
+		loc:    loc,
+	}
+}
+
+// Format 返回t所在周期的索引名
+func (f *IndexFormatter) Format(t time.Time) string {
+	t = t.In(f.loc)
+	switch f.period {
+	case PeriodHourly:
+		return f.prefix + "_" + t.Format("2006-01-02-15")
+	case PeriodWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%s_%d-w%02d", f.prefix, year, week)
+	default:
+		return f.prefix + "_" + t.Format("2006-01-02")
+	}
+}
+
+// Now 返回当前时间的索引名
+func (f *IndexFormatter) Now() string {
+	return f.Format(time.Now())
+}
+
+// DataStreamData 生成写入数据流的数据，数据流只接受create，并且必须有@timestamp
+// data中没有@timestamp时使用ts
+func DataStreamData(stream string, ts time.Time, data interface{}) (EsData, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return EsData{}, fmt.Errorf("marshal data %+v failed, err:%w", data, err)
+	}
+	var doc map[string]json.RawMessage
+	if err = json.Unmarshal(b, &doc); err != nil {
+		return EsData{}, fmt.Errorf("data stream document must be a json object, err:%w", err)
+	}
+	if doc == nil {
+		doc = make(map[string]json.RawMessage)
+	}
+	if _, ok := doc["@timestamp"]; !ok {
+		doc["@timestamp"], _ = json.Marshal(ts.Format(time.RFC3339Nano))
+	}
+	return EsData{Index: stream, Op: OpCreate, Data: doc}, nil
+}
+
+// Requester 执行es的管理请求，OlivereSink 和 HTTPSink 都实现了这个接口
+type Requester interface {
+	Request(ctx context.Context, method, path string, body interface{}) (int, []byte, error)
+}
+
+// IndexSetup 启动时创建的ILM策略、索引模板和数据流，策略和模板已存在时覆盖
+type IndexSetup struct {
+	// PolicyName Policy ILM策略，Policy为策略的内容，即 {"policy": {...}}
+	PolicyName string
+	Policy     interface{}
+	// TemplateName Template 可组合索引模板，即 _index_template 的请求体
+	TemplateName string
+	Template     interface{}
+	// DataStream 需要提前创建的数据流，模板中需要有 "data_stream": {}
+	DataStream string
+}
+
+// Apply 按策略、模板、数据流的顺序创建，空的项跳过
+func (p IndexSetup) Apply(ctx context.Context, r Requester) error {
+	if p.PolicyName != "" {
+		if err := request(ctx, r, http.MethodPut, "/_ilm/policy/"+p.PolicyName, p.Policy); err != nil {
+			return fmt.Errorf("put ilm policy %s failed, err:%w", p.PolicyName, err)
+		}
+	}
+	if p.TemplateName != "" {
+		if err := request(ctx, r, http.MethodPut, "/_index_template/"+p.TemplateName, p.Template); err != nil {
+			return fmt.Errorf("put index template %s failed, err:%w", p.TemplateName, err)
+		}
+	}
+	if p.DataStream != "" {
+		status, body, err := r.Request(ctx, http.MethodPut, "/_data_stream/"+p.DataStream, nil)
+		if err != nil {
+			return fmt.Errorf("create data stream %s failed, err:%w", p.DataStream, err)
+		}
+		//已存在时返回400 resource_already_exists_exception
+		if status >= 300 && !(status == http.StatusBadRequest && alreadyExists(body)) {
+			return fmt.Errorf("create data stream %s failed with status %d, body:%s", p.DataStream, status, body)
+		}
+	}
+	return nil
+}
+
+// SetupIndex 使用BatES的Sink执行 IndexSetup
+func (s *BatES) SetupIndex(ctx context.Context, p IndexSetup) error {
+	r, ok := s.sink.(Requester)
+	if !ok {
+		return fmt.Errorf("sink %T does not support requests", s.sink)
+	}
+	return p.Apply(ctx, r)
+}
+
+func request(ctx context.Context, r Requester, method, path string, body interface{}) error {
+	status, resp, err := r.Request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if status >= 300 {
+		return fmt.Errorf("status %d, body:%s", status, resp)
+	}
+	return nil
+}
+
+func alreadyExists(body []byte) bool {
+	var ret struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &ret)
+	return ret.Error.Type == "resource_already_exists_exception"
+}
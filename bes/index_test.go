@@ -0,0 +1,97 @@
+package bes
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIndexFormatter(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 23, 30, 0, 0, time.UTC)
+	cst := time.FixedZone("CST", 8*3600)
+	cases := []struct {
+		f    *IndexFormatter
+		want string
+	}{
+		{NewIndexFormatter("log", PeriodDaily, nil), "log_2025-01-01"},
+		{NewIndexFormatter("log", PeriodDaily, cst), "log_2025-01-02"},
+		{NewIndexFormatter("log", PeriodHourly, nil), "log_2025-01-01-23"},
+		{NewIndexFormatter("log", PeriodWeekly, nil), "log_2025-w01"},
+	}
+	for _, c := range cases {
+		if got := c.f.Format(ts); got != c.want {
+			t.Errorf("Expected %s, got %s", c.want, got)
+		}
+	}
+	//2024-12-30 属于2025年第1周
+	if got := NewIndexFormatter("log", PeriodWeekly, nil).Format(time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC)); got != "log_2025-w01" {
+		t.Errorf("Expected ISO week, got %s", got)
+	}
+}
+
+func TestDataStreamData(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	d, err := DataStreamData("logs-app-default", ts, map[string]string{"msg": "hello"})
+	if err != nil {
+		t.Fatalf("Failed to build data: %v", err)
+	}
+	if d.Op != OpCreate || d.Index != "logs-app-default" {
+		t.Errorf("Unexpected data %+v", d)
+	}
+	b, _ := json.Marshal(d.Data)
+	if !strings.Contains(string(b), `"@timestamp":"2025-01-01T00:00:00Z"`) {
+		t.Errorf("Expected @timestamp, got %s", b)
+	}
+
+	d, _ = DataStreamData("logs-app-default", ts, map[string]string{"@timestamp": "2020-01-01T00:00:00Z"})
+	if b, _ = json.Marshal(d.Data); !strings.Contains(string(b), "2020-01-01") {
+		t.Errorf("Expected original @timestamp kept, got %s", b)
+	}
+	if _, err = DataStreamData("logs-app-default", ts, "text"); err == nil {
+		t.Error("Expected error for non-object document")
+	}
+}
+
+func TestIndexSetup(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+string(body))
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/_data_stream/") {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":{"type":"resource_already_exists_exception"},"status":400}`)
+			return
+		}
+		io.WriteString(w, `{"acknowledged":true}`)
+	}))
+	defer srv.Close()
+
+	setup := IndexSetup{
+		PolicyName:   "logs",
+		Policy:       map[string]interface{}{"policy": map[string]interface{}{"phases": map[string]interface{}{}}},
+		TemplateName: "logs",
+		Template:     map[string]interface{}{"index_patterns": []string{"logs-app-*"}, "data_stream": map[string]interface{}{}},
+		DataStream:   "logs-app-default",
+	}
+	if err := setup.Apply(context.Background(), NewHTTPSink(HTTPSinkArgs{URL: srv.URL})); err != nil {
+		t.Fatalf("Failed to apply setup: %v", err)
+	}
+	if len(calls) != 3 || !strings.HasPrefix(calls[0], "PUT /_ilm/policy/logs {") ||
+		!strings.HasPrefix(calls[1], "PUT /_index_template/logs {") || calls[2] != "PUT /_data_stream/logs-app-default " {
+		t.Errorf("Unexpected calls %q", calls)
+	}
+
+	es := &BatES{sink: &FileSink{}}
+	if err := es.SetupIndex(context.Background(), setup); err == nil {
+		t.Error("Expected error for sink without requests")
+	}
+}
@@ -0,0 +1,252 @@
+package bes
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultLane = "default"
+
+// LaneArgs 按索引名划分的通道，每个通道有独立的队列、batch和提交间隔
+// 没有匹配任何通道的数据使用 BesArgs 中的配置，优先级为0
+type LaneArgs struct {
+	// Name 通道名，用于统计，默认为第一个Pattern
+	Name string
+	// Patterns 索引名的匹配规则，使用path.Match的语法，如 audit-*，按配置顺序使用第一个匹配的通道
+	Patterns []string
+	// Priority 优先级，worker先提交优先级高的batch
+	Priority int
+	// ChannelSize BatchSize BatchBytes FlushInterval 为0时使用 BesArgs 中的配置
+	ChannelSize   int
+	BatchSize     int
+	BatchBytes    int64
+	FlushInterval time.Duration
+	// ShedPending 提交中的数据达到这个条数时丢弃这个通道新提交的数据，Submit 返回 ErrShed，0表示不丢弃
+	ShedPending int
+}
+
+// LaneStats 通道的计数
+type LaneStats struct {
+	Name     string
+	Priority int
+	// QueueDepth 通道队列中等待的条数
+	QueueDepth    int
+	QueueCapacity int
+	// Shed 压力大时丢弃的条数
+	Shed int64
+}
+
+type lane struct {
+	name          string
+	patterns      []string
+	priority      int
+	input         chan EsData
+	batchSize     int
+	batchBytes    int64
+	flushInterval time.Duration
+	shedPending   int64
+	shed          int64
+}
+
+// newLanes 创建配置的通道，最后一个是默认通道，配置无效时panic
+func newLanes(p BesArgs) []*lane {
+	lanes := make([]*lane, 0, len(p.Lanes)+1)
+	names := map[string]bool{defaultLane: true}
+	for _, c := range p.Lanes {
+		if len(c.Patterns) == 0 {
+			panic(fmt.Sprintf("lane %s has no patterns", c.Name))
+		}
+		for _, pattern := range c.Patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				panic(fmt.Sprintf("invalid lane pattern %s, err:%s", pattern, err))
+			}
+		}
+		if c.Name == "" {
+			c.Name = c.Patterns[0]
+		}
+		if names[c.Name] {
+			panic(fmt.Sprintf("duplicate lane %s", c.Name))
+		}
+		names[c.Name] = true
+		if c.ChannelSize <= 0 {
+			c.ChannelSize = p.ChannelSize
+		}
+		if c.BatchSize <= 0 {
+			c.BatchSize = p.BatchSize
+		}
+		if c.BatchBytes <= 0 {
+			c.BatchBytes = p.BatchBytes
+		}
+		if c.FlushInterval <= 0 {
+			c.FlushInterval = p.FlushInterval
+		}
+		lanes = append(lanes, &lane{
+			name:          c.Name,
+			patterns:      c.Patterns,
+			priority:      c.Priority,
+			input:         make(chan EsData, c.ChannelSize),
+			batchSize:     c.BatchSize,
+			batchBytes:    c.BatchBytes,
+			flushInterval: c.FlushInterval,
+			shedPending:   int64(c.ShedPending),
+		})
+	}
+	return append(lanes, &lane{
+		name:          defaultLane,
+		input:         make(chan EsData, p.ChannelSize),
+		batchSize:     p.BatchSize,
+		batchBytes:    p.BatchBytes,
+		flushInterval: p.FlushInterval,
+	})
+}
+
+func (l *lane) match(index string) bool {
+	for _, pattern := range l.patterns {
+		if ok, _ := path.Match(pattern, index); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// full 条数或字节数达到阈值
+func (l *lane) full(batch *esBatch) bool {
+	if len(batch.items) >= l.batchSize {
+		return true
+	}
+	return l.batchBytes > 0 && batch.size >= l.batchBytes
+}
+
+// route 返回索引对应的通道，没有配置通道时返回nil
+// 按天、按租户的索引名不断变化，不缓存匹配结果
+func (s *BatES) route(index string) *lane {
+	if len(s.lanes) == 0 {
+		return nil
+	}
+	for _, l := range s.lanes[:len(s.lanes)-1] {
+		if l.match(index) {
+			return l
+		}
+	}
+	return s.lanes[len(s.lanes)-1]
+}
+
+// shedding 通道压力大，需要丢弃新数据
+func (s *BatES) shedding(l *lane) bool {
+	return l != nil && l.shedPending > 0 && atomic.LoadInt64(&s.pending) >= l.shedPending
+}
+
+// runLane 按通道的条数、字节数和时间间隔分批交给worker提交，停止时提交剩余的数据
+func (s *BatES) runLane(l *lane, callback ErrorCallback) {
+	defer s.laneWg.Done()
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	batch := s.newBatch(l.priority)
+	for {
+		select {
+		case item := <-l.input:
+			s.add(batch, item, true)
+			if l.full(batch) {
+				s.dispatch(batch, callback)
+				batch = s.newBatch(l.priority)
+			}
+		case <-ticker.C:
+			if len(batch.items) != 0 {
+				s.dispatch(batch, callback)
+				batch = s.newBatch(l.priority)
+			}
+		case <-s.draining:
+			s.drain(l, batch, callback)
+			return
+		}
+	}
+}
+
+// LaneStats 返回各通道的计数，默认通道在最后
+func (s *BatES) LaneStats() []LaneStats {
+	stats := make([]LaneStats, 0, len(s.lanes))
+	for _, l := range s.lanes {
+		stats = append(stats, LaneStats{
+			Name:          l.name,
+			Priority:      l.priority,
+			QueueDepth:    len(l.input),
+			QueueCapacity: cap(l.input),
+			Shed:          atomic.LoadInt64(&l.shed),
+		})
+	}
+	return stats
+}
+
+// batchQueue 等待worker提交的batch，优先级高的先出队，相同优先级先进先出
+type batchQueue struct {
+	mu    sync.Mutex
+	items []*esBatch
+	// space 限制队列长度，ready 通知worker有batch
+	space chan struct{}
+	ready chan struct{}
+}
+
+func newBatchQueue(size int) *batchQueue {
+	return &batchQueue{
+		space: make(chan struct{}, size),
+		ready: make(chan struct{}, size),
+	}
+}
+
+// push 队列满时阻塞直到有空间或ctx结束
+func (q *batchQueue) push(ctx context.Context, batch *esBatch) error {
+	select {
+	case q.space <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	q.insert(batch)
+	return nil
+}
+
+// tryPush 队列满时返回false
+func (q *batchQueue) tryPush(batch *esBatch) bool {
+	select {
+	case q.space <- struct{}{}:
+	default:
+		return false
+	}
+	q.insert(batch)
+	return true
+}
+
+func (q *batchQueue) insert(batch *esBatch) {
+	q.mu.Lock()
+	i := len(q.items)
+	for i > 0 && q.items[i-1].priority < batch.priority {
+		i--
+	}
+	q.items = append(q.items, nil)
+	copy(q.items[i+1:], q.items[i:])
+	q.items[i] = batch
+	q.mu.Unlock()
+	q.ready <- struct{}{}
+}
+
+// pop 等待下一个batch，队列关闭并且为空时返回nil
+func (q *batchQueue) pop() *esBatch {
+	if _, ok := <-q.ready; !ok {
+		return nil
+	}
+	q.mu.Lock()
+	batch := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.mu.Unlock()
+	<-q.space
+	return batch
+}
+
+// close 不再有batch入队后调用
+func (q *batchQueue) close() {
+	close(q.ready)
+}
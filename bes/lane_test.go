@@ -0,0 +1,109 @@
+package bes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateSink 等待gate关闭后才返回，记录每次bulk请求的索引
+type gateSink struct {
+	mu    sync.Mutex
+	gate  chan struct{}
+	order []string
+}
+
+func (g *gateSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	g.mu.Lock()
+	g.order = append(g.order, fmt.Sprintf("%s:%d", items[0].Index, len(items)))
+	g.mu.Unlock()
+	<-g.gate
+	results := make([]BulkResult, len(items))
+	for i := range results {
+		results[i].Status = http.StatusCreated
+	}
+	return results, nil
+}
+
+func TestLaneRoute(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: &recordSink{}, Snowflake: sf, Lanes: []LaneArgs{
+		{Patterns: []string{"audit-*"}, Priority: 10},
+		{Name: "debug", Patterns: []string{"debug-*", "trace"}, ChannelSize: 5},
+	}})
+	defer es.Stop(context.Background())
+	cases := map[string]string{"audit-2025": "audit-*", "debug-a": "debug", "trace": "debug", "log": defaultLane, "": defaultLane}
+	for index, name := range cases {
+		if l := es.route(index); l.name != name {
+			t.Errorf("Expected index %q in lane %s, got %s", index, name, l.name)
+		}
+	}
+	stats := es.LaneStats()
+	if len(stats) != 3 || stats[1].QueueCapacity != 5 || stats[2].QueueCapacity != defaultChannelSize {
+		t.Errorf("Unexpected lane stats %+v", stats)
+	}
+}
+
+func TestLanePriority(t *testing.T) {
+	sink := &gateSink{gate: make(chan struct{})}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, BatchSize: 1, Lanes: []LaneArgs{
+		{Patterns: []string{"audit"}, Priority: 10, BatchSize: 2},
+	}})
+	_ = es.Submit(context.Background(), EsData{Index: "log"})
+	//第一个batch占用worker后，低优先级的batch先入队
+	deadline := time.Now().Add(2 * time.Second)
+	for es.Stats().InFlightDocs != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_ = es.Submit(context.Background(), EsData{Index: "log"})
+	for len(es.queue.ready) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_ = es.Submit(context.Background(), EsData{Index: "audit"})
+	_ = es.Submit(context.Background(), EsData{Index: "audit"})
+	for len(es.queue.ready) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(sink.gate)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := es.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if fmt.Sprint(sink.order) != "[log:1 audit:2 log:1]" {
+		t.Errorf("Expected audit batch before queued log batch, got %v", sink.order)
+	}
+}
+
+func TestLaneShed(t *testing.T) {
+	sink := &gateSink{gate: make(chan struct{})}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, BatchSize: 2, Lanes: []LaneArgs{
+		{Patterns: []string{"debug"}, ShedPending: 2},
+	}})
+	for i := 0; i < 2; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log"})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for es.Stats().Pending != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := es.Submit(context.Background(), EsData{Index: "debug"}); err != ErrShed {
+		t.Fatalf("Expected ErrShed, got %v", err)
+	}
+	if !es.TrySubmit(EsData{Index: "log"}) {
+		t.Error("Expected default lane not shed")
+	}
+	close(sink.gate)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = es.Stop(ctx)
+	stats := es.Stats()
+	if stats.Submit.Shed != 1 || stats.Lanes[0].Shed != 1 || stats.Indexed != 3 {
+		t.Errorf("Expected 1 shed and 3 indexed, got %+v", stats)
+	}
+}
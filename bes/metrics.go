@@ -0,0 +1,207 @@
+package bes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHealthWindow = 30 * time.Second
+
+// latencyBuckets bulk请求耗时直方图的上界，单位秒
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// esMetrics BatES的计数，全部用原子操作
+type esMetrics struct {
+	received      int64
+	indexed       int64
+	retried       int64
+	duplicates    int64
+	mirrorDropped int64
+	bulkRequests  int64
+	bulkFailures  int64
+	inFlightDocs  int64
+	inFlightBytes int64
+	// failures 连续失败的bulk请求数
+	failures    int64
+	lastSuccess int64
+	latency     [13]int64
+	latencySum  int64
+}
+
+// LatencyStats bulk请求耗时直方图
+type LatencyStats struct {
+	// Buckets 上界，单位秒
+	Buckets []float64
+	// Counts 耗时不超过对应上界的请求数，累计值，最后一个为全部请求数
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+// Stats BatES的运行状态
+type Stats struct {
+	// Received 进入batch的条数，磁盘队列中重新读取的数据会再次计数
+	Received int64
+	// Indexed 写入成功的条数
+	Indexed int64
+	// Retried 重试的条数，同一条数据重试多次时多次计数
+	Retried int64
+	// Duplicates 去重模式下已经存在的条数，计入Indexed
+	Duplicates int64
+	// Dropped 放弃的条数
+	Dropped int64
+	// MirrorDropped Mirror无法接收而没有镜像的条数
+	MirrorDropped int64
+	BulkRequests  int64
+	BulkFailures  int64
+	// InFlightDocs InFlightBytes 正在提交的bulk请求中的条数和估计字节数，没有字节数限制时InFlightBytes为0
+	InFlightDocs  int64
+	InFlightBytes int64
+	// QueueDepth 输入队列中等待的条数，包含所有通道
+	QueueDepth    int
+	QueueCapacity int
+	// Pending 已经进入batch还没有结果的条数
+	Pending     int
+	Latency     LatencyStats
+	LastSuccess time.Time
+	Healthy     bool
+	Submit      SubmitStats
+	// Lanes 各通道的计数
+	Lanes []LaneStats
+}
+
+// Stats 返回当前的计数
+func (s *BatES) Stats() Stats {
+	m := &s.metrics
+	stats := Stats{
+		Received:      atomic.LoadInt64(&m.received),
+		Indexed:       atomic.LoadInt64(&m.indexed),
+		Retried:       atomic.LoadInt64(&m.retried),
+		Duplicates:    atomic.LoadInt64(&m.duplicates),
+		Dropped:       atomic.LoadInt64(&s.dropped),
+		MirrorDropped: atomic.LoadInt64(&m.mirrorDropped),
+		BulkRequests:  atomic.LoadInt64(&m.bulkRequests),
+		BulkFailures:  atomic.LoadInt64(&m.bulkFailures),
+		InFlightDocs:  atomic.LoadInt64(&m.inFlightDocs),
+		InFlightBytes: atomic.LoadInt64(&m.inFlightBytes),
+		QueueDepth:    len(s.input),
+		QueueCapacity: cap(s.input),
+		Lanes:         s.LaneStats(),
+		Pending:       int(atomic.LoadInt64(&s.pending)),
+		Latency: LatencyStats{
+			Buckets: latencyBuckets,
+			Counts:  make([]int64, len(latencyBuckets)+1),
+			Sum:     time.Duration(atomic.LoadInt64(&m.latencySum)),
+		},
+		Healthy: s.Healthy(),
+		Submit:  s.SubmitStats(),
+	}
+	if len(stats.Lanes) > 0 {
+		stats.QueueDepth, stats.QueueCapacity = 0, 0
+		for _, l := range stats.Lanes {
+			stats.QueueDepth += l.QueueDepth
+			stats.QueueCapacity += l.QueueCapacity
+		}
+	}
+	var total int64
+	for i := range stats.Latency.Counts {
+		total += atomic.LoadInt64(&m.latency[i])
+		stats.Latency.Counts[i] = total
+	}
+	stats.Latency.Count = total
+	if t := atomic.LoadInt64(&m.lastSuccess); t > 0 {
+		stats.LastSuccess = time.Unix(0, t)
+	}
+	return stats
+}
+
+// Healthy 最近一次bulk请求成功，或连续失败但在健康窗口内有过成功的请求
+func (s *BatES) Healthy() bool {
+	m := &s.metrics
+	if atomic.LoadInt64(&m.failures) == 0 {
+		return true
+	}
+	t := atomic.LoadInt64(&m.lastSuccess)
+	return t > 0 && time.Since(time.Unix(0, t)) < s.healthWindow
+}
+
+// observe 记录一次bulk请求的结果和耗时
+func (m *esMetrics) observe(d time.Duration, err error) {
+	atomic.AddInt64(&m.bulkRequests, 1)
+	atomic.AddInt64(&m.latencySum, int64(d))
+	i := 0
+	for i < len(latencyBuckets) && d.Seconds() > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&m.latency[i], 1)
+	if err != nil {
+		atomic.AddInt64(&m.bulkFailures, 1)
+		atomic.AddInt64(&m.failures, 1)
+		return
+	}
+	atomic.StoreInt64(&m.failures, 0)
+	atomic.StoreInt64(&m.lastSuccess, time.Now().UnixNano())
+}
+
+// WritePrometheus 以Prometheus文本格式输出计数
+func (s *BatES) WritePrometheus(w io.Writer) error {
+	stats := s.Stats()
+	healthy := 0
+	if stats.Healthy {
+		healthy = 1
+	}
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value int64
+	}{
+		{"bes_documents_received_total", "counter", "Documents added to bulk batches.", stats.Received},
+		{"bes_documents_indexed_total", "counter", "Documents written to elasticsearch.", stats.Indexed},
+		{"bes_documents_retried_total", "counter", "Document retry attempts.", stats.Retried},
+		{"bes_documents_duplicate_total", "counter", "Documents that already existed in dedup mode.", stats.Duplicates},
+		{"bes_documents_dropped_total", "counter", "Documents given up on.", stats.Dropped},
+		{"bes_mirror_dropped_total", "counter", "Documents the mirror could not accept.", stats.MirrorDropped},
+		{"bes_submit_accepted_total", "counter", "Documents accepted into the input queue.", stats.Submit.Accepted},
+		{"bes_submit_spilled_total", "counter", "Documents spilled to disk because the input queue was full.", stats.Submit.Spilled},
+		{"bes_submit_dropped_total", "counter", "Documents dropped because the input queue was full.", stats.Submit.Dropped},
+		{"bes_submit_rejected_total", "counter", "Documents rejected by Submit.", stats.Submit.Rejected},
+		{"bes_bulk_requests_total", "counter", "Bulk requests sent.", stats.BulkRequests},
+		{"bes_bulk_failures_total", "counter", "Bulk requests that failed as a whole.", stats.BulkFailures},
+		{"bes_bulk_in_flight_documents", "gauge", "Documents in bulk requests being sent.", stats.InFlightDocs},
+		{"bes_bulk_in_flight_bytes", "gauge", "Estimated bytes of bulk requests being sent.", stats.InFlightBytes},
+		{"bes_queue_depth", "gauge", "Documents waiting in the input queue.", int64(stats.QueueDepth)},
+		{"bes_queue_capacity", "gauge", "Capacity of the input queue.", int64(stats.QueueCapacity)},
+		{"bes_pending_documents", "gauge", "Documents waiting for a bulk result.", int64(stats.Pending)},
+		{"bes_healthy", "gauge", "1 if recent bulk requests succeeded.", int64(healthy)},
+	}
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value); err != nil {
+			return err
+		}
+	}
+	const name = "bes_bulk_duration_seconds"
+	if _, err := fmt.Fprintf(w, "# HELP %s Bulk request latency.\n# TYPE %s histogram\n", name, name); err != nil {
+		return err
+	}
+	for i, le := range stats.Latency.Buckets {
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), stats.Latency.Counts[i]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n",
+		name, stats.Latency.Count, name, stats.Latency.Sum.Seconds(), name, stats.Latency.Count)
+	return err
+}
+
+// PrometheusHandler 返回输出Prometheus文本格式的http.Handler
+func (s *BatES) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = s.WritePrometheus(w)
+	})
+}
@@ -0,0 +1,51 @@
+package bes
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsAndHealthy(t *testing.T) {
+	s := &BatES{input: make(chan EsData, 4), pending: 1, healthWindow: time.Hour}
+	s.input <- EsData{}
+	if !s.Healthy() {
+		t.Error("Expected healthy before any bulk request")
+	}
+	s.metrics.observe(3*time.Millisecond, nil)
+	s.metrics.observe(200*time.Millisecond, errors.New("connection refused"))
+	if !s.Healthy() {
+		t.Error("Expected healthy within window after a recent success")
+	}
+	s.healthWindow = 0
+	if s.Healthy() {
+		t.Error("Expected unhealthy after failure outside window")
+	}
+
+	stats := s.Stats()
+	if stats.BulkRequests != 2 || stats.BulkFailures != 1 || stats.QueueDepth != 1 || stats.QueueCapacity != 4 || stats.Pending != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.Latency.Count != 2 || stats.Latency.Counts[0] != 1 || stats.Latency.Counts[5] != 2 {
+		t.Errorf("Unexpected latency %+v", stats.Latency)
+	}
+
+	var buf bytes.Buffer
+	if err := s.WritePrometheus(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	for _, line := range []string{
+		"bes_bulk_requests_total 2",
+		"bes_healthy 0",
+		"bes_queue_depth 1",
+		`bes_bulk_duration_seconds_bucket{le="0.005"} 1`,
+		`bes_bulk_duration_seconds_bucket{le="+Inf"} 2`,
+		"bes_bulk_duration_seconds_count 2",
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected line %q in output:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package bes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// OpType bulk操作类型
+type OpType string
+
+const (
+	// OpIndex 写入文档，存在时覆盖，默认
+	OpIndex OpType = "index"
+	// OpCreate 写入文档，存在时返回409
+	OpCreate OpType = "create"
+	// OpUpdate 部分更新或脚本更新，可以带upsert
+	OpUpdate OpType = "update"
+	// OpDelete 删除文档
+	OpDelete OpType = "delete"
+)
+
+// request 根据操作类型生成bulk请求
+func (d EsData) request() (elastic.BulkableRequest, error) {
+	switch d.Op {
+	case "", OpIndex, OpCreate:
+		req := elastic.NewBulkIndexRequest().Index(d.Index).Id(d.Id).Doc(d.Data)
+		if d.Op == OpCreate {
+			req.OpType(string(OpCreate))
+		}
+		if d.Routing != "" {
+			req.Routing(d.Routing)
+		}
+		if d.Pipeline != "" {
+			req.Pipeline(d.Pipeline)
+		}
+		if d.Version > 0 {
+			req.Version(d.Version)
+		}
+		if d.VersionType != "" {
+			req.VersionType(d.VersionType)
+		}
+		if d.IfSeqNo != nil {
+			req.IfSeqNo(*d.IfSeqNo)
+		}
+		if d.IfPrimaryTerm != nil {
+			req.IfPrimaryTerm(*d.IfPrimaryTerm)
+		}
+		return req, nil
+	case OpUpdate:
+		if d.Id == "" {
+			return nil, fmt.Errorf("update data %+v without id", d.Data)
+		}
+		req := elastic.NewBulkUpdateRequest().Index(d.Index).Id(d.Id)
+		if !isNull(d.Data) {
+			req.Doc(d.Data)
+		}
+		if d.Script != "" {
+			req.Script(elastic.NewScript(d.Script).Params(d.ScriptParams))
+			req.ScriptedUpsert(d.ScriptedUpsert)
+		}
+		if !isNull(d.Upsert) {
+			req.Upsert(d.Upsert)
+		}
+		if d.DocAsUpsert {
+			req.DocAsUpsert(true)
+		}
+		if d.RetryOnConflict > 0 {
+			req.RetryOnConflict(d.RetryOnConflict)
+		}
+		if d.Routing != "" {
+			req.Routing(d.Routing)
+		}
+		if d.IfSeqNo != nil {
+			req.IfSeqNo(*d.IfSeqNo)
+		}
+		if d.IfPrimaryTerm != nil {
+			req.IfPrimaryTerm(*d.IfPrimaryTerm)
+		}
+		return req, nil
+	case OpDelete:
+		if d.Id == "" {
+			return nil, fmt.Errorf("delete data in index %s without id", d.Index)
+		}
+		req := elastic.NewBulkDeleteRequest().Index(d.Index).Id(d.Id)
+		if d.Routing != "" {
+			req.Routing(d.Routing)
+		}
+		if d.Version > 0 {
+			req.Version(d.Version)
+		}
+		if d.VersionType != "" {
+			req.VersionType(d.VersionType)
+		}
+		if d.IfSeqNo != nil {
+			req.IfSeqNo(*d.IfSeqNo)
+		}
+		if d.IfPrimaryTerm != nil {
+			req.IfPrimaryTerm(*d.IfPrimaryTerm)
+		}
+		return req, nil
+	}
+	return nil, fmt.Errorf("unknown op type %q", d.Op)
+}
+
+// isNull 空值或从磁盘读出的json null
+func isNull(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+	}
+	return false
+}
+
+// rawEsData 从磁盘读取 EsData，Data和Upsert保留原始json
+type rawEsData struct {
+	EsData
+	Data   json.RawMessage `json:"data"`
+	Upsert json.RawMessage `json:"upsert,omitempty"`
+}
+
+func (r rawEsData) esData() EsData {
+	d := r.EsData
+	d.Data = nil
+	d.Upsert = nil
+	if !isNull(r.Data) {
+		d.Data = r.Data
+	}
+	if !isNull(r.Upsert) {
+		d.Upsert = r.Upsert
+	}
+	return d
+}
@@ -0,0 +1,72 @@
+package bes
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEsDataRequest(t *testing.T) {
+	seqNo, term := int64(3), int64(1)
+	cases := []struct {
+		data   EsData
+		action string
+		source string
+	}{
+		{EsData{Id: "1", Index: "log", Data: map[string]int{"a": 1}}, `{"index":{"_index":"log","_id":"1"}}`, `{"a":1}`},
+		{EsData{Id: "1", Index: "log", Data: map[string]int{"a": 1}, Op: OpCreate}, `{"create":{"_index":"log","_id":"1"}}`, `{"a":1}`},
+		{EsData{Id: "1", Index: "log", Data: map[string]int{"a": 1}, Op: OpUpdate, DocAsUpsert: true}, `{"update":{"_index":"log","_id":"1"}}`, `{"doc":{"a":1},"doc_as_upsert":true}`},
+		{EsData{Id: "1", Index: "log", Op: OpDelete, IfSeqNo: &seqNo, IfPrimaryTerm: &term}, `{"delete":{"_index":"log","_id":"1","if_seq_no":3,"if_primary_term":1}}`, ""},
+	}
+	for _, c := range cases {
+		req, err := c.data.request()
+		if err != nil {
+			t.Fatalf("Failed to build request for %+v: %v", c.data, err)
+		}
+		lines, err := req.Source()
+		if err != nil {
+			t.Fatalf("Failed to get source: %v", err)
+		}
+		if lines[0] != c.action {
+			t.Errorf("Expected action %s, got %s", c.action, lines[0])
+		}
+		if c.source != "" && (len(lines) < 2 || lines[1] != c.source) {
+			t.Errorf("Expected source %s, got %v", c.source, lines)
+		}
+	}
+
+	for _, d := range []EsData{{Index: "log", Op: OpUpdate}, {Index: "log", Op: OpDelete}, {Index: "log", Op: "merge"}} {
+		if _, err := d.request(); err == nil {
+			t.Errorf("Expected error for %+v", d)
+		}
+	}
+}
+
+func TestSpillQueueOps(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	defer q.Close()
+	err = q.Push(
+		EsData{Id: "1", Index: "log", Op: OpDelete},
+		EsData{Id: "2", Index: "log", Op: OpUpdate, Script: "ctx._source.n += 1", Upsert: map[string]int{"n": 1}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	items, _, err := q.peek(10)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("Expected 2 items, got %d, err: %v", len(items), err)
+	}
+	if items[0].Op != OpDelete || items[0].Data != nil {
+		t.Errorf("Unexpected delete item: %+v", items[0])
+	}
+	req, err := items[1].request()
+	if err != nil {
+		t.Fatalf("Failed to build update request: %v", err)
+	}
+	lines, _ := req.Source()
+	if len(lines) != 2 || !strings.Contains(lines[1], `"upsert":{"n":1}`) || !strings.Contains(lines[1], `ctx._source.n += 1`) {
+		t.Errorf("Unexpected update source: %v", lines)
+	}
+}
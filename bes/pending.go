@@ -0,0 +1,79 @@
+package bes
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// pending 已经进入batch还没有结果的一条数据，只属于一个batch
+type pending struct {
+	item  EsData
+	size  int64
+	retry int
+	added time.Time
+}
+
+// docs 返回batch中的数据
+func (b *esBatch) docs() []EsData {
+	items := make([]EsData, len(b.items))
+	for i, p := range b.items {
+		items[i] = p.item
+	}
+	return items
+}
+
+// reset 清空batch，返回清空前的数据
+func (b *esBatch) reset() []*pending {
+	items := b.items
+	b.items = nil
+	b.size = 0
+	return items
+}
+
+// acquire 占用一个位置，提交中的数据达到MaxPending时阻塞
+func (s *BatES) acquire() {
+	if s.slots != nil {
+		s.slots <- struct{}{}
+	}
+	atomic.AddInt64(&s.pending, 1)
+}
+
+// finish n条数据有了结果，释放位置
+func (s *BatES) finish(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&s.pending, -int64(n))
+	if s.slots != nil {
+		for i := 0; i < n; i++ {
+			<-s.slots
+		}
+	}
+}
+
+// evict 删除batch中超过MaxAge的数据，写入死信，返回删除的条数
+func (s *BatES) evict(batch *esBatch, callback ErrorCallback) int {
+	if s.maxAge <= 0 {
+		return 0
+	}
+	now := time.Now()
+	var dead []DeadItem
+	kept := batch.items[:0]
+	batch.size = 0
+	for _, p := range batch.items {
+		if now.Sub(p.added) > s.maxAge {
+			dead = append(dead, deadItem(p, fmt.Sprintf("pending for more than %s", s.maxAge), 0))
+			continue
+		}
+		kept = append(kept, p)
+		batch.size += p.size
+	}
+	batch.items = kept
+	if len(dead) > 0 {
+		callback(fmt.Errorf("drop %d documents pending for more than %s", len(dead), s.maxAge))
+		s.finish(len(dead))
+		s.bury(dead, callback)
+	}
+	return len(dead)
+}
@@ -0,0 +1,105 @@
+package bes
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failSink 每次请求都返回传输错误
+type failSink struct {
+	calls int64
+}
+
+func (f *failSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	atomic.AddInt64(&f.calls, 1)
+	return nil, errors.New("connection refused")
+}
+
+func TestPendingBoundedUnderFailures(t *testing.T) {
+	sink := &failSink{}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{
+		Sink:          sink,
+		Snowflake:     sf,
+		Retry:         1,
+		Backoff:       NewBackoff(time.Millisecond, time.Millisecond, 1),
+		ChannelSize:   10,
+		BatchSize:     10,
+		Workers:       2,
+		FlushInterval: 5 * time.Millisecond,
+	})
+	limit := cap(es.slots)
+	if limit != 60 {
+		t.Fatalf("Expected MaxPending raised to 60, got %d", limit)
+	}
+
+	done := make(chan struct{})
+	var peak int64
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+			if n := int64(es.Stats().Pending); n > peak {
+				peak = n
+			}
+		}
+	}()
+	<-done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dropped, _ := es.Stop(ctx)
+
+	if peak > int64(limit) {
+		t.Errorf("Pending grew to %d, limit %d", peak, limit)
+	}
+	stats := es.Stats()
+	if stats.Pending != 0 || len(es.slots) != 0 {
+		t.Errorf("Expected nothing pending after stop, got %d, slots %d", stats.Pending, len(es.slots))
+	}
+	if stats.Dropped != 1000 || stats.Indexed != 0 {
+		t.Errorf("Expected all 1000 documents dropped, got %+v, stop dropped %d", stats, dropped)
+	}
+	if atomic.LoadInt64(&sink.calls) == 0 {
+		t.Error("Expected bulk requests")
+	}
+}
+
+func TestEvictMaxAge(t *testing.T) {
+	var errs []error
+	s := &BatES{maxAge: time.Minute, pending: 2}
+	batch := &esBatch{items: []*pending{
+		{item: EsData{Id: "old"}, size: 10, added: time.Now().Add(-2 * time.Minute)},
+		{item: EsData{Id: "new"}, size: 20, added: time.Now()},
+	}, size: 30}
+	if n := s.evict(batch, func(err error) { errs = append(errs, err) }); n != 1 {
+		t.Fatalf("Expected 1 evicted, got %d", n)
+	}
+	if len(batch.items) != 1 || batch.items[0].item.Id != "new" || batch.size != 20 {
+		t.Errorf("Unexpected batch after evict: %+v", batch)
+	}
+	if s.pending != 1 || len(errs) != 1 {
+		t.Errorf("Expected pending 1 and one callback, got %d, %v", s.pending, errs)
+	}
+}
+
+func TestMaxPendingTooSmall(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	p := BesArgs{Sink: &failSink{}, Snowflake: sf, ChannelSize: 100, BatchSize: 10, Workers: 2}
+	//下限为60，配置的值不再被悄悄提高
+	p.MaxPending = 60
+	es := NewBatES(p)
+	if cap(es.slots) != 60 {
+		t.Errorf("Expected configured MaxPending 60 kept, got %d", cap(es.slots))
+	}
+	es.Stop(context.Background())
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for MaxPending below the deadlock bound")
+		}
+	}()
+	p.MaxPending = 59
+	NewBatES(p)
+}
@@ -0,0 +1,231 @@
+package bes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadSize  = 1000
+	defaultKeepAlive = time.Minute
+)
+
+// Hit 读取到的一条数据
+type Hit struct {
+	Index  string          `json:"_index"`
+	Id     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+	Sort   []interface{}   `json:"sort,omitempty"`
+}
+
+// ReaderArgs Reader 的配置
+type ReaderArgs struct {
+	// Requester 执行查询，OlivereSink 和 HTTPSink 都可以使用
+	Requester Requester
+	// Index 索引名，可以使用通配符，如 log_2025-01-*
+	Index string
+	// Query 查询条件，即请求体中的query，为空时读取全部数据
+	Query interface{}
+	// Size 每次请求的条数，默认1000
+	Size int
+	// Sort 设置后使用search_after分页，最后一个排序字段需要唯一，为空时使用scroll
+	Sort []interface{}
+	// KeepAlive scroll的保持时间，默认1分钟
+	KeepAlive time.Duration
+}
+
+// Reader 按scroll或search_after分页读取索引中的数据
+type Reader struct {
+	requester Requester
+	index     string
+	query     interface{}
+	size      int
+	sort      []interface{}
+	keepAlive time.Duration
+}
+
+func NewReader(p ReaderArgs) *Reader {
+	if p.Requester == nil {
+		panic("requester is nil")
+	}
+	if p.Index == "" {
+		panic("index is empty")
+	}
+	if p.Size <= 0 {
+		p.Size = defaultReadSize
+	}
+	if p.KeepAlive <= 0 {
+		p.KeepAlive = defaultKeepAlive
+	}
+	return &Reader{
+		requester: p.Requester,
+		index:     p.Index,
+		query:     p.Query,
+		size:      p.Size,
+		sort:      p.Sort,
+		keepAlive: p.KeepAlive,
+	}
+}
+
+// searchResult 查询返回的数据
+type searchResult struct {
+	ScrollId string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []Hit `json:"hits"`
+	} `json:"hits"`
+}
+
+// Read 读取全部数据写入out，读完、出错或ctx结束时返回读取的条数，不关闭out
+func (r *Reader) Read(ctx context.Context, out chan<- Hit) (int, error) {
+	if len(r.sort) > 0 {
+		return r.searchAfter(ctx, out)
+	}
+	return r.scroll(ctx, out)
+}
+
+func (r *Reader) body() map[string]interface{} {
+	body := map[string]interface{}{"size": r.size}
+	if r.query != nil {
+		body["query"] = r.query
+	}
+	return body
+}
+
+func (r *Reader) scroll(ctx context.Context, out chan<- Hit) (int, error) {
+	keepAlive := fmt.Sprintf("%dms", r.keepAlive.Milliseconds())
+	body := r.body()
+	body["sort"] = []string{"_doc"}
+	ret, err := r.search(ctx, http.MethodPost, "/"+r.index+"/_search?scroll="+keepAlive, body)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if ret.ScrollId != "" {
+			//ctx可能已经结束
+			clearCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, _, _ = r.requester.Request(clearCtx, http.MethodDelete, "/_search/scroll", map[string]interface{}{"scroll_id": []string{ret.ScrollId}})
+		}
+	}()
+	n := 0
+	for len(ret.Hits.Hits) > 0 {
+		if err = send(ctx, out, ret.Hits.Hits); err != nil {
+			return n, err
+		}
+		n += len(ret.Hits.Hits)
+		next, err := r.search(ctx, http.MethodPost, "/_search/scroll", map[string]interface{}{"scroll": keepAlive, "scroll_id": ret.ScrollId})
+		if err != nil {
+			return n, err
+		}
+		if next.ScrollId == "" {
+			next.ScrollId = ret.ScrollId
+		}
+		ret = next
+	}
+	return n, nil
+}
+
+func (r *Reader) searchAfter(ctx context.Context, out chan<- Hit) (int, error) {
+	body := r.body()
+	body["sort"] = r.sort
+	n := 0
+	for {
+		ret, err := r.search(ctx, http.MethodPost, "/"+r.index+"/_search", body)
+		if err != nil {
+			return n, err
+		}
+		hits := ret.Hits.Hits
+		if len(hits) == 0 {
+			return n, nil
+		}
+		if err = send(ctx, out, hits); err != nil {
+			return n, err
+		}
+		n += len(hits)
+		last := hits[len(hits)-1].Sort
+		if len(last) == 0 {
+			return n, fmt.Errorf("search_after needs sort values in hits")
+		}
+		body["search_after"] = last
+	}
+}
+
+func (r *Reader) search(ctx context.Context, method, path string, body interface{}) (*searchResult, error) {
+	status, resp, err := r.requester.Request(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	if status >= 300 {
+		return nil, fmt.Errorf("search %s failed with status %d, body:%s", path, status, resp)
+	}
+	var ret searchResult
+	if err = json.Unmarshal(resp, &ret); err != nil {
+		return nil, fmt.Errorf("decode search response failed, err:%w", err)
+	}
+	return &ret, nil
+}
+
+func send(ctx context.Context, out chan<- Hit, hits []Hit) error {
+	for _, hit := range hits {
+		select {
+		case out <- hit:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Transform 把读取到的数据转换为要写入的数据，返回false时跳过
+type Transform func(hit Hit) (EsData, bool, error)
+
+// ReindexArgs Reindex 的配置
+type ReindexArgs struct {
+	Reader *Reader
+	Dest   *BatES
+	// DestIndex 没有Transform时写入的索引，为空时使用原索引名
+	DestIndex string
+	// Transform 可以为空，默认保留id和内容
+	Transform Transform
+}
+
+// Reindex 读取Reader中的数据提交到Dest，返回提交的条数
+// 数据写入es的结果由Dest的重试和死信处理，需要等待写完时调用Dest.Stop
+func Reindex(ctx context.Context, p ReindexArgs) (int, error) {
+	if p.Transform == nil {
+		p.Transform = func(hit Hit) (EsData, bool, error) {
+			index := p.DestIndex
+			if index == "" {
+				index = hit.Index
+			}
+			return EsData{Id: hit.Id, Index: index, Data: hit.Source}, true, nil
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hits := make(chan Hit, p.Reader.size)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := p.Reader.Read(ctx, hits)
+		close(hits)
+		readErr <- err
+	}()
+	n := 0
+	for hit := range hits {
+		d, ok, err := p.Transform(hit)
+		if err != nil {
+			return n, fmt.Errorf("transform %s/%s failed, err:%w", hit.Index, hit.Id, err)
+		}
+		if !ok {
+			continue
+		}
+		if err = p.Dest.Submit(ctx, d); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, <-readErr
+}
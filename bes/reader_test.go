@@ -0,0 +1,115 @@
+package bes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// searchServer 模拟25条数据的scroll和search_after查询
+func searchServer() (*httptest.Server, *int) {
+	var mu sync.Mutex
+	cleared := 0
+	page := func(w http.ResponseWriter, from, size int, scrollId string) {
+		var hits []string
+		for i := from; i < from+size && i < 25; i++ {
+			id := fmt.Sprintf("%02d", i)
+			hits = append(hits, fmt.Sprintf(`{"_index":"log_2025-01-01","_id":%q,"_source":{"n":%d},"sort":[%q]}`, id, i, id))
+		}
+		fmt.Fprintf(w, `{"_scroll_id":%q,"hits":{"hits":[%s]}}`, scrollId, strings.Join(hits, ","))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Size        int           `json:"size"`
+			ScrollId    string        `json:"scroll_id"`
+			SearchAfter []string      `json:"search_after"`
+			Sort        []interface{} `json:"sort"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/_search/scroll":
+			mu.Lock()
+			cleared++
+			mu.Unlock()
+			fmt.Fprint(w, `{"succeeded":true}`)
+		case r.URL.Path == "/_search/scroll":
+			from, _ := strconv.Atoi(body.ScrollId)
+			page(w, from, 10, strconv.Itoa(from+10))
+		case r.URL.Path == "/log_2025-01-01/_search" && r.URL.Query().Get("scroll") != "":
+			page(w, 0, body.Size, strconv.Itoa(body.Size))
+		case r.URL.Path == "/log_2025-01-01/_search" && len(body.Sort) > 0:
+			from := 0
+			if len(body.SearchAfter) > 0 {
+				from, _ = strconv.Atoi(body.SearchAfter[0])
+				from++
+			}
+			page(w, from, body.Size, "")
+		default:
+			http.Error(w, r.URL.String(), http.StatusNotFound)
+		}
+	}))
+	return srv, &cleared
+}
+
+func TestReader(t *testing.T) {
+	srv, cleared := searchServer()
+	defer srv.Close()
+	sink := NewHTTPSink(HTTPSinkArgs{URL: srv.URL})
+	for _, sort := range [][]interface{}{nil, {map[string]string{"id": "asc"}}} {
+		r := NewReader(ReaderArgs{Requester: sink, Index: "log_2025-01-01", Size: 10, Sort: sort})
+		out := make(chan Hit, 100)
+		n, err := r.Read(context.Background(), out)
+		close(out)
+		if err != nil || n != 25 {
+			t.Fatalf("Expected 25 hits, got %d, err: %v", n, err)
+		}
+		i := 0
+		for hit := range out {
+			if hit.Id != fmt.Sprintf("%02d", i) {
+				t.Fatalf("Expected hit %d, got %s", i, hit.Id)
+			}
+			i++
+		}
+	}
+	if *cleared != 1 {
+		t.Errorf("Expected scroll cleared once, got %d", *cleared)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	srv, _ := searchServer()
+	defer srv.Close()
+	dest := &createSink{seen: map[string]bool{}}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: dest, Snowflake: sf, FlushInterval: 5 * time.Millisecond})
+	n, err := Reindex(context.Background(), ReindexArgs{
+		Reader: NewReader(ReaderArgs{Requester: NewHTTPSink(HTTPSinkArgs{URL: srv.URL}), Index: "log_2025-01-01", Size: 10}),
+		Dest:   es,
+		Transform: func(hit Hit) (EsData, bool, error) {
+			var doc map[string]int
+			if err := json.Unmarshal(hit.Source, &doc); err != nil {
+				return EsData{}, false, err
+			}
+			//只迁移偶数
+			return EsData{Id: hit.Id, Index: "log-migrated", Data: doc}, doc["n"]%2 == 0, nil
+		},
+	})
+	if err != nil || n != 13 {
+		t.Fatalf("Expected 13 documents reindexed, got %d, err: %v", n, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = es.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if len(dest.seen) != 13 || !dest.seen["00"] || dest.seen["01"] {
+		t.Errorf("Unexpected documents written: %v", dest.seen)
+	}
+}
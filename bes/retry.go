@@ -0,0 +1,116 @@
+package bes
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultBackoffInitial = 100 * time.Millisecond
+	defaultBackoffMax     = 10 * time.Second
+)
+
+// ConflictPolicy 版本冲突(409)的处理方式
+type ConflictPolicy int
+
+const (
+	// ConflictDrop 丢弃并回调，默认
+	ConflictDrop ConflictPolicy = iota
+	// ConflictRetry 按可重试错误处理
+	ConflictRetry
+	// ConflictIgnore 视为写入成功
+	ConflictIgnore
+)
+
+// 单条数据失败后的处理方式
+const (
+	actionRetry = iota
+	actionDrop
+	actionSucceed
+)
+
+// Backoff 带随机抖动的指数退避，实现 elastic.Backoff
+type Backoff struct {
+	initial    time.Duration
+	max        time.Duration
+	maxRetries int
+}
+
+// NewBackoff 第n次重试等待 [d/2, d]，d = min(initial*2^(n-1), max)，超过maxRetries次后停止
+func NewBackoff(initial, max time.Duration, maxRetries int) *Backoff {
+	if initial <= 0 {
+		initial = defaultBackoffInitial
+	}
+	if max < initial {
+		max = initial
+	}
+	return &Backoff{
+		initial:    initial,
+		max:        max,
+		maxRetries: maxRetries,
+	}
+}
+
+// Next 实现 elastic.Backoff
+func (b *Backoff) Next(retry int) (time.Duration, bool) {
+	if retry > b.maxRetries {
+		return 0, false
+	}
+	d := b.initial
+	for i := 1; i < retry && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1)), true
+}
+
+// retryableStatus 限流和服务端错误可以重试
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// classify 根据bulk返回的单条结果决定重试、丢弃或视为成功
+func (s *BatES) classify(item *BulkResult, op OpType) int {
+	switch {
+	case item.Status == http.StatusNotFound && item.Result == "not_found":
+		//删除不存在的文档
+		return actionSucceed
+	case retryableStatus(item.Status):
+		return actionRetry
+	case item.Status == http.StatusConflict:
+		//去重模式下create返回409说明已经写入过
+		if s.dedup && op == OpCreate {
+			return actionSucceed
+		}
+		switch s.onConflict {
+		case ConflictRetry:
+			return actionRetry
+		case ConflictIgnore:
+			return actionSucceed
+		}
+		return actionDrop
+	default:
+		//400 mapping错误等，重试也不会成功
+		return actionDrop
+	}
+}
+
+// sleep 等待d或ctx结束，ctx结束返回false
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
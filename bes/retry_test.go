@@ -0,0 +1,54 @@
+package bes
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		status     int
+		result     string
+		op         OpType
+		onConflict ConflictPolicy
+		dedup      bool
+		action     int
+	}{
+		{status: http.StatusTooManyRequests, action: actionRetry},
+		{status: http.StatusServiceUnavailable, action: actionRetry},
+		{status: http.StatusInternalServerError, action: actionRetry},
+		{status: http.StatusBadRequest, action: actionDrop},
+		{status: http.StatusNotFound, action: actionDrop},
+		{status: http.StatusNotFound, result: "not_found", op: OpDelete, action: actionSucceed},
+		{status: http.StatusConflict, action: actionDrop},
+		{status: http.StatusConflict, onConflict: ConflictRetry, action: actionRetry},
+		{status: http.StatusConflict, onConflict: ConflictIgnore, action: actionSucceed},
+		{status: http.StatusConflict, op: OpCreate, dedup: true, action: actionSucceed},
+		{status: http.StatusConflict, op: OpUpdate, dedup: true, action: actionDrop},
+	}
+	for _, c := range cases {
+		s := &BatES{onConflict: c.onConflict, dedup: c.dedup}
+		if action := s.classify(&BulkResult{Status: c.status, Result: c.result}, c.op); action != c.action {
+			t.Errorf("Expected action %d for %+v, got %d", c.action, c, action)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(10*time.Millisecond, 50*time.Millisecond, 5)
+	//第n次等待 [d/2, d]，d翻倍直到上限
+	for retry, max := range []time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 5: 50} {
+		if retry == 0 {
+			continue
+		}
+		max *= time.Millisecond
+		d, ok := b.Next(retry)
+		if !ok || d < max/2 || d > max {
+			t.Errorf("Expected retry %d to wait in [%v, %v], got %v, %v", retry, max/2, max, d, ok)
+		}
+	}
+	if _, ok := b.Next(6); ok {
+		t.Error("Expected no more retries after maxRetries")
+	}
+}
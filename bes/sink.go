@@ -0,0 +1,323 @@
+package bes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Sink 执行一次bulk请求，返回每条数据的结果，顺序和items一致
+// 整个请求失败时返回error，BatES按退避策略重试
+type Sink interface {
+	Bulk(ctx context.Context, items []EsData) ([]BulkResult, error)
+}
+
+// BulkResult bulk返回的单条结果
+type BulkResult struct {
+	Index  string     `json:"_index"`
+	Id     string     `json:"_id"`
+	Status int        `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  *BulkError `json:"error,omitempty"`
+}
+
+// BulkError bulk返回的单条错误
+type BulkError struct {
+	Type     string                 `json:"type"`
+	Reason   string                 `json:"reason"`
+	CausedBy map[string]interface{} `json:"caused_by,omitempty"`
+}
+
+// Succeeded 状态码为2xx
+func (r *BulkResult) Succeeded() bool {
+	return r.Status >= 200 && r.Status <= 299
+}
+
+// bulkBody 生成 _bulk 请求的NDJSON
+func bulkBody(items []EsData) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, item := range items {
+		req, err := item.request()
+		if err != nil {
+			return nil, err
+		}
+		lines, err := req.Source()
+		if err != nil {
+			return nil, fmt.Errorf("encode data %+v failed, err:%w", item.Data, err)
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// OlivereSink 使用 olivere/elastic v7 的客户端
+type OlivereSink struct {
+	client  *elastic.Client
+	retrier elastic.Retrier
+}
+
+// NewOlivereSink retrier可以为空，压缩请求体需要创建client时使用 elastic.SetGzip(true)
+func NewOlivereSink(client *elastic.Client, retrier elastic.Retrier) *OlivereSink {
+	return &OlivereSink{
+		client:  client,
+		retrier: retrier,
+	}
+}
+
+func (o *OlivereSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	bulk := o.client.Bulk()
+	if o.retrier != nil {
+		bulk.Retrier(o.retrier)
+	}
+	for _, item := range items {
+		req, err := item.request()
+		if err != nil {
+			return nil, err
+		}
+		bulk.Add(req)
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BulkResult, 0, len(resp.Items))
+	for _, m := range resp.Items {
+		for _, r := range m {
+			result := BulkResult{
+				Index:  r.Index,
+				Id:     r.Id,
+				Status: r.Status,
+				Result: r.Result,
+			}
+			if r.Error != nil {
+				result.Error = &BulkError{
+					Type:     r.Error.Type,
+					Reason:   r.Error.Reason,
+					CausedBy: r.Error.CausedBy,
+				}
+			}
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// Request 实现 Requester，非2xx的状态码不返回错误
+func (o *OlivereSink) Request(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	resp, err := o.client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: method,
+		Path:   path,
+		Body:   body,
+	})
+	if e, ok := err.(*elastic.Error); ok {
+		b, _ := json.Marshal(map[string]interface{}{"error": e.Details, "status": e.Status})
+		return e.Status, b, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, resp.Body, nil
+}
+
+// HTTPSinkArgs HTTPSink 的配置
+type HTTPSinkArgs struct {
+	// URL 集群地址，如 http://127.0.0.1:9200
+	URL      string
+	Username string
+	Password string
+	// APIKey base64编码的api key，设置后代替用户名密码
+	APIKey string
+	Header http.Header
+	// Gzip 压缩bulk请求体
+	Gzip bool
+	// Client 默认 http.DefaultClient
+	Client *http.Client
+}
+
+// HTTPSink 直接请求 _bulk 接口，兼容 Elasticsearch 7/8 和 OpenSearch
+type HTTPSink struct {
+	base     string
+	url      string
+	username string
+	password string
+	apiKey   string
+	header   http.Header
+	gzip     bool
+	client   *http.Client
+}
+
+func NewHTTPSink(p HTTPSinkArgs) *HTTPSink {
+	if p.URL == "" {
+		panic("url is empty")
+	}
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	base := strings.TrimRight(p.URL, "/")
+	return &HTTPSink{
+		base:     base,
+		url:      base + "/_bulk",
+		username: p.Username,
+		password: p.Password,
+		apiKey:   p.APIKey,
+		header:   p.Header,
+		gzip:     p.Gzip,
+		client:   p.Client,
+	}
+}
+
+func (h *HTTPSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	body, err := bulkBody(items)
+	if err != nil {
+		return nil, err
+	}
+	if h.gzip {
+		if body, err = gzipBody(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := h.newRequest(ctx, http.MethodPost, h.url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if h.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("bulk request failed with status %d, body:%s", resp.StatusCode, msg)
+	}
+	var ret struct {
+		Items []map[string]BulkResult `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("decode bulk response failed, err:%w", err)
+	}
+	results := make([]BulkResult, 0, len(ret.Items))
+	for _, m := range ret.Items {
+		for _, r := range m {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// Request 实现 Requester，非2xx的状态码不返回错误
+func (h *HTTPSink) Request(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+	req, err := h.newRequest(ctx, method, h.base+path, b)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err = io.ReadAll(resp.Body)
+	return resp.StatusCode, b, err
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// newRequest 设置公共的header和认证信息
+func (h *HTTPSink) newRequest(ctx context.Context, method, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h.header {
+		req.Header[k] = v
+	}
+	switch {
+	case h.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+h.apiKey)
+	case h.username != "":
+		req.SetBasicAuth(h.username, h.password)
+	}
+	return req, nil
+}
+
+// FileSink 把数据写入 json lines 文件，用于测试和本地调试
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open sink file %s failed, err:%w", path, err)
+	}
+	return &FileSink{file: f}, nil
+}
+
+func (fs *FileSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	var buf bytes.Buffer
+	results := make([]BulkResult, 0, len(items))
+	for _, item := range items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return nil, fmt.Errorf("marshal data %+v failed, err:%w", item.Data, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		result := BulkResult{Index: item.Index, Id: item.Id, Status: http.StatusCreated, Result: "created"}
+		switch item.Op {
+		case OpUpdate:
+			result.Status, result.Result = http.StatusOK, "updated"
+		case OpDelete:
+			result.Status, result.Result = http.StatusOK, "deleted"
+		}
+		results = append(results, result)
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, err := fs.file.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (fs *FileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.file.Close()
+}
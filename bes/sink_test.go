@@ -0,0 +1,215 @@
+package bes
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPSink(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "elastic" || pass != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var items []string
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var action map[string]map[string]string
+			if err := json.Unmarshal(sc.Bytes(), &action); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for op, meta := range action {
+				status := 201
+				if meta["_id"] == "bad" {
+					status = 400
+				}
+				items = append(items, fmt.Sprintf(`{%q:{"_index":%q,"_id":%q,"status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}`, op, meta["_index"], meta["_id"], status))
+				if op != "delete" {
+					sc.Scan()
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(HTTPSinkArgs{URL: srv.URL + "/", Username: "elastic", Password: "secret"})
+	results, err := sink.Bulk(context.Background(), []EsData{
+		{Id: "1", Index: "log", Data: map[string]int{"a": 1}},
+		{Id: "2", Index: "log", Op: OpDelete},
+		{Id: "bad", Index: "log", Data: map[string]int{"a": 1}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to bulk: %v", err)
+	}
+	if len(results) != 3 || !results[0].Succeeded() || !results[1].Succeeded() || results[2].Succeeded() {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	if results[2].Error == nil || results[2].Error.Type != "mapper_parsing_exception" {
+		t.Errorf("Expected error detail, got %+v", results[2])
+	}
+
+	sink = NewHTTPSink(HTTPSinkArgs{URL: srv.URL})
+	if _, err = sink.Bulk(context.Background(), []EsData{{Id: "1", Index: "log"}}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected status error, got %v", err)
+	}
+}
+
+func TestFileSinkWithBatES(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.json")
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Failed to create sink: %v", err)
+	}
+	defer sink.Close()
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: sink, Snowflake: sf, BatchSize: 7, FlushInterval: 10 * time.Millisecond})
+	for i := 0; i < 20; i++ {
+		if err = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}}); err != nil {
+			t.Fatalf("Failed to submit: %v", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err = es.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if stats := es.Stats(); stats.Indexed != 20 {
+		t.Errorf("Expected 20 indexed, got %+v", stats)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read sink file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 20 {
+		t.Fatalf("Expected 20 lines, got %d", len(lines))
+	}
+	var item EsData
+	if err = json.Unmarshal([]byte(lines[0]), &item); err != nil || item.Id == "" || item.Index != "log" {
+		t.Errorf("Unexpected line %s, err: %v", lines[0], err)
+	}
+}
+
+func TestHTTPSinkGzip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "expected gzip", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(zr)
+		if !strings.HasPrefix(string(body), `{"index":{"_index":"log","_id":"1"}}`) {
+			http.Error(w, "unexpected body", http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"errors":false,"items":[{"index":{"_index":"log","_id":"1","status":201}}]}`)
+	}))
+	defer srv.Close()
+
+	sink := NewHTTPSink(HTTPSinkArgs{URL: srv.URL, Gzip: true})
+	results, err := sink.Bulk(context.Background(), []EsData{{Id: "1", Index: "log", Data: map[string]int{"a": 1}}})
+	if err != nil || len(results) != 1 || !results[0].Succeeded() {
+		t.Fatalf("Unexpected results %+v, err: %v", results, err)
+	}
+}
+
+// recordSink 记录每次bulk请求的条数
+type recordSink struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (r *recordSink) Bulk(ctx context.Context, items []EsData) ([]BulkResult, error) {
+	r.mu.Lock()
+	r.sizes = append(r.sizes, len(items))
+	r.mu.Unlock()
+	results := make([]BulkResult, len(items))
+	for i, item := range items {
+		results[i] = BulkResult{Index: item.Index, Id: item.Id, Status: http.StatusCreated}
+	}
+	return results, nil
+}
+
+func TestSplitBulkAndMaxDocBytes(t *testing.T) {
+	sink := &recordSink{}
+	doc := func(i int) EsData {
+		return EsData{Id: fmt.Sprintf("%02d", i), Index: "log", Data: map[string]string{"msg": strings.Repeat("x", 20)}}
+	}
+	//每个请求最多3条
+	size, _ := requestSize(doc(0))
+	var mu sync.Mutex
+	var errs []string
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{
+		Sink:          sink,
+		Snowflake:     sf,
+		BatchSize:     10,
+		MaxBulkBytes:  3 * size,
+		FlushInterval: time.Hour,
+		Callback: func(err error) {
+			mu.Lock()
+			errs = append(errs, err.Error())
+			mu.Unlock()
+		},
+	})
+	for i := 0; i < 10; i++ {
+		_ = es.Submit(context.Background(), doc(i))
+	}
+	_ = es.Submit(context.Background(), EsData{Id: "big", Index: "log", Data: map[string]string{"msg": strings.Repeat("x", 400)}})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dropped, _ := es.Stop(ctx)
+
+	if dropped != 1 || es.Stats().Indexed != 10 {
+		t.Errorf("Expected 10 indexed and 1 dropped, got %d dropped, %+v", dropped, es.Stats())
+	}
+	if fmt.Sprint(sink.sizes) != "[3 3 3 1]" {
+		t.Errorf("Expected 10 documents split into [3 3 3 1], got %v", sink.sizes)
+	}
+	if len(errs) == 0 || !strings.Contains(errs[0], fmt.Sprintf("exceeds max document size %d", 3*size)) {
+		t.Errorf("Expected max document size error, got %v", errs)
+	}
+}
+
+func TestPrepareMeasure(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	item := EsData{Index: "log", Data: map[string]string{"msg": "x"}}
+	plain := NewBatES(BesArgs{Sink: &recordSink{}, Snowflake: sf})
+	defer plain.Stop(context.Background())
+	//没有字节数限制时不编码数据
+	if size, err := plain.prepare(&item); err != nil || size != 0 {
+		t.Errorf("Expected size 0 without byte limits, got %d, %v", size, err)
+	}
+	if _, err := plain.prepare(&EsData{Index: "log", Op: OpUpdate}); err == nil {
+		t.Error("Expected update without id rejected")
+	}
+	limited := NewBatES(BesArgs{Sink: &recordSink{}, Snowflake: sf, Lanes: []LaneArgs{{Patterns: []string{"audit"}, BatchBytes: 1 << 20}}})
+	defer limited.Stop(context.Background())
+	if size, err := limited.prepare(&item); err != nil || size == 0 {
+		t.Errorf("Expected size measured with lane BatchBytes, got %d, %v", size, err)
+	}
+}
@@ -6,71 +6,238 @@ import (
 	"time"
 )
 
-const (
-	// 时间戳位数 (41位，可以使用69年)
-	timestampBits = 41
-	// 数据中心ID位数 (5位，最多32个数据中心)
-	datacenterBits = 5
-	// 机器ID位数 (5位，每个数据中心最多32台机器)
-	machineBits = 5
-	// 序列号位数 (12位，每毫秒最多4096个ID)
-	sequenceBits = 12
-
-	// 最大值
-	maxDatacenterID = (1 << datacenterBits) - 1
-	maxMachineID    = (1 << machineBits) - 1
-	maxSequence     = (1 << sequenceBits) - 1
-
-	// 偏移量
-	machineIDShift    = sequenceBits
-	datacenterIDShift = sequenceBits + machineBits
-	timestampShift    = sequenceBits + machineBits + datacenterBits
-
-	// 时间戳起始点 (2020-01-01 00:00:00 UTC)
-	epoch = int64(1577836800000)
-)
+// Layout Snowflake ID 的位数分配和时间戳起始点，各部分位数之和不能超过63
+type Layout struct {
+	// TimestampBits 毫秒时间戳位数
+	TimestampBits int
+	// DatacenterBits 数据中心ID位数
+	DatacenterBits int
+	// MachineBits 机器ID位数
+	MachineBits int
+	// SequenceBits 序列号位数，决定每毫秒最多生成的ID数
+	SequenceBits int
+	// ClockSeqBits 时钟序列号位数，在时间戳之后，ClockSequence 策略使用，最多8位
+	ClockSeqBits int
+	// Epoch 时间戳起始点
+	Epoch time.Time
+}
+
+// DefaultLayout 默认布局
+// 41位时间戳可以使用69年，最多32个数据中心，每个数据中心最多32台机器，每毫秒最多4096个ID
+var DefaultLayout = Layout{
+	TimestampBits:  41,
+	DatacenterBits: 5,
+	MachineBits:    5,
+	SequenceBits:   12,
+	Epoch:          time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// Validate 检查位数和时间戳起始点，当前时间需要在时间戳位数的范围内
+func (l Layout) Validate() error {
+	if l.TimestampBits <= 0 || l.SequenceBits <= 0 {
+		return fmt.Errorf("timestamp and sequence bits must be positive")
+	}
+	if l.DatacenterBits < 0 || l.MachineBits < 0 {
+		return fmt.Errorf("datacenter and machine bits must not be negative")
+	}
+	if l.ClockSeqBits < 0 || l.ClockSeqBits > maxClockSeqBits {
+		return fmt.Errorf("clock sequence bits must be between 0 and %d", maxClockSeqBits)
+	}
+	if total := l.TimestampBits + l.ClockSeqBits + l.DatacenterBits + l.MachineBits + l.SequenceBits; total > 63 {
+		return fmt.Errorf("total bits %d exceeds 63", total)
+	}
+	if l.Epoch.IsZero() {
+		return fmt.Errorf("epoch is not set")
+	}
+	elapsed := time.Now().UnixMilli() - l.epoch()
+	if elapsed < 0 {
+		return fmt.Errorf("epoch %s is in the future", l.Epoch)
+	}
+	if elapsed > l.maxTimestamp() {
+		return fmt.Errorf("%d timestamp bits overflowed since epoch %s", l.TimestampBits, l.Epoch)
+	}
+	return nil
+}
+
+// MaxDatacenterID 最大的数据中心ID
+func (l Layout) MaxDatacenterID() int64 {
+	return 1<<l.DatacenterBits - 1
+}
+
+// MaxMachineID 最大的机器ID
+func (l Layout) MaxMachineID() int64 {
+	return 1<<l.MachineBits - 1
+}
+
+// MaxSequence 最大的序列号
+func (l Layout) MaxSequence() int64 {
+	return 1<<l.SequenceBits - 1
+}
+
+func (l Layout) maxTimestamp() int64 {
+	return 1<<l.TimestampBits - 1
+}
+
+func (l Layout) epoch() int64 {
+	return l.Epoch.UnixMilli()
+}
+
+func (l Layout) machineIDShift() int {
+	return l.SequenceBits
+}
+
+func (l Layout) datacenterIDShift() int {
+	return l.SequenceBits + l.MachineBits
+}
+
+func (l Layout) clockSeqShift() int {
+	return l.SequenceBits + l.MachineBits + l.DatacenterBits
+}
+
+func (l Layout) timestampShift() int {
+	return l.SequenceBits + l.MachineBits + l.DatacenterBits + l.ClockSeqBits
+}
+
+// Timestamp 从 ID 中提取毫秒时间戳
+func (l Layout) Timestamp(id int64) int64 {
+	return (id >> l.timestampShift()) + l.epoch()
+}
+
+// ClockSequence 从 ID 中提取时钟序列号
+func (l Layout) ClockSequence(id int64) int64 {
+	return (id >> l.clockSeqShift()) & (1<<l.ClockSeqBits - 1)
+}
+
+// DatacenterID 从 ID 中提取数据中心ID
+func (l Layout) DatacenterID(id int64) int64 {
+	return (id >> l.datacenterIDShift()) & l.MaxDatacenterID()
+}
+
+// MachineID 从 ID 中提取机器ID
+func (l Layout) MachineID(id int64) int64 {
+	return (id >> l.machineIDShift()) & l.MaxMachineID()
+}
+
+// Sequence 从 ID 中提取序列号
+func (l Layout) Sequence(id int64) int64 {
+	return id & l.MaxSequence()
+}
+
+// Parse 按布局解析 ID
+func (l Layout) Parse(id int64) map[string]int64 {
+	return map[string]int64{
+		"timestamp":      l.Timestamp(id),
+		"clock_sequence": l.ClockSequence(id),
+		"datacenter_id":  l.DatacenterID(id),
+		"machine_id":     l.MachineID(id),
+		"sequence":       l.Sequence(id),
+	}
+}
+
+// SnowflakeArgs Snowflake 的配置
+type SnowflakeArgs struct {
+	// Layout 为空时使用 DefaultLayout
+	Layout       Layout
+	DatacenterID int64
+	MachineID    int64
+	// Clock 系统时钟回退时的处理方式，默认 ClockReject
+	Clock ClockPolicy
+	// MaxDrift ClockWait 和 ClockSequence 允许的最大回退时间，超过时返回 ErrClockBackwards，默认1秒
+	MaxDrift time.Duration
+}
 
 // Snowflake Snowflake ID 生成器
 type Snowflake struct {
 	mutex         sync.Mutex
+	layout        Layout
 	lastTimestamp int64
 	datacenterID  int64
 	machineID     int64
 	sequence      int64
+	clock         ClockPolicy
+	maxDrift      int64
+	// clockSeq 当前的时钟序列号，clockUsed 每个时钟序列号用过的最大时间戳
+	clockSeq  int64
+	clockUsed []int64
+	// anchor anchorMillis ClockMonotonic 锚定的时间
+	anchor       time.Time
+	anchorMillis int64
+	// wall 系统时钟的毫秒时间戳
+	wall func() int64
 }
 
-// NewSnowflake 创建新的 Snowflake 实例
+// NewSnowflake 使用 DefaultLayout 创建新的 Snowflake 实例
 func NewSnowflake(datacenterID, machineID int64) (*Snowflake, error) {
-	if datacenterID < 0 || datacenterID > maxDatacenterID {
-		return nil, fmt.Errorf("datacenter ID must be between 0 and %d", maxDatacenterID)
+	return NewSnowflakeWithLayout(DefaultLayout, datacenterID, machineID)
+}
+
+// NewSnowflakeWithLayout 使用指定的布局创建 Snowflake 实例
+func NewSnowflakeWithLayout(layout Layout, datacenterID, machineID int64) (*Snowflake, error) {
+	return NewSnowflakeWithArgs(SnowflakeArgs{Layout: layout, DatacenterID: datacenterID, MachineID: machineID})
+}
+
+// NewSnowflakeWithArgs 按配置创建 Snowflake 实例
+func NewSnowflakeWithArgs(p SnowflakeArgs) (*Snowflake, error) {
+	if p.Layout == (Layout{}) {
+		p.Layout = DefaultLayout
+	}
+	layout := p.Layout
+	if err := layout.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid snowflake layout, err:%s", err)
+	}
+	if p.DatacenterID < 0 || p.DatacenterID > layout.MaxDatacenterID() {
+		return nil, fmt.Errorf("datacenter ID must be between 0 and %d", layout.MaxDatacenterID())
 	}
-	if machineID < 0 || machineID > maxMachineID {
-		return nil, fmt.Errorf("machine ID must be between 0 and %d", maxMachineID)
+	if p.MachineID < 0 || p.MachineID > layout.MaxMachineID() {
+		return nil, fmt.Errorf("machine ID must be between 0 and %d", layout.MaxMachineID())
+	}
+	if p.Clock == ClockSequence && layout.ClockSeqBits == 0 {
+		return nil, fmt.Errorf("clock sequence policy needs clock sequence bits in layout")
+	}
+	if p.MaxDrift <= 0 {
+		p.MaxDrift = defaultMaxDrift
 	}
 
+	now := time.Now()
 	return &Snowflake{
+		layout:        layout,
 		lastTimestamp: 0,
-		datacenterID:  datacenterID,
-		machineID:     machineID,
+		datacenterID:  p.DatacenterID,
+		machineID:     p.MachineID,
 		sequence:      0,
+		clock:         p.Clock,
+		maxDrift:      p.MaxDrift.Milliseconds(),
+		clockUsed:     make([]int64, 1<<layout.ClockSeqBits),
+		anchor:        now,
+		anchorMillis:  now.UnixMilli(),
+		wall:          func() int64 { return time.Now().UnixMilli() },
 	}, nil
 }
 
+// Layout 返回使用的布局
+func (s *Snowflake) Layout() Layout {
+	return s.layout
+}
+
 // NextID 生成下一个 ID
 func (s *Snowflake) NextID() (int64, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	l := &s.layout
 	now := s.getCurrentTimestamp()
 
 	// 如果当前时间小于上次生成时间，说明系统时钟回退了
 	if now < s.lastTimestamp {
-		return 0, fmt.Errorf("clock moved backwards, refusing to generate ID for %d milliseconds", s.lastTimestamp-now)
+		var err error
+		if now, err = s.backwards(now); err != nil {
+			return 0, err
+		}
 	}
 
 	// 如果是同一毫秒内，增加序列号
 	if now == s.lastTimestamp {
-		s.sequence = (s.sequence + 1) & maxSequence
+		s.sequence = (s.sequence + 1) & l.MaxSequence()
 		// 如果序列号溢出，等待下一毫秒
 		if s.sequence == 0 {
 			now = s.waitNextMillis(s.lastTimestamp)
@@ -80,22 +247,22 @@ func (s *Snowflake) NextID() (int64, error) {
 		s.sequence = 0
 	}
 
+	elapsed := now - l.epoch()
+	if elapsed > l.maxTimestamp() {
+		return 0, fmt.Errorf("%d timestamp bits overflowed since epoch %s", l.TimestampBits, l.Epoch)
+	}
 	s.lastTimestamp = now
 
 	// 生成ID
-	id := ((now - epoch) << timestampShift) |
-		(s.datacenterID << datacenterIDShift) |
-		(s.machineID << machineIDShift) |
+	id := (elapsed << l.timestampShift()) |
+		(s.clockSeq << l.clockSeqShift()) |
+		(s.datacenterID << l.datacenterIDShift()) |
+		(s.machineID << l.machineIDShift()) |
 		s.sequence
 
 	return id, nil
 }
 
-// getCurrentTimestamp 获取当前时间戳（毫秒）
-func (s *Snowflake) getCurrentTimestamp() int64 {
-	return time.Now().UnixNano() / 1e6
-}
-
 // waitNextMillis 等待下一毫秒
 func (s *Snowflake) waitNextMillis(lastTimestamp int64) int64 {
 	timestamp := s.getCurrentTimestamp()
@@ -105,39 +272,29 @@ func (s *Snowflake) waitNextMillis(lastTimestamp int64) int64 {
 	return timestamp
 }
 
-// ParseID 解析 Snowflake ID
+// ParseID 按实例的布局解析 Snowflake ID
 func (s *Snowflake) ParseID(id int64) map[string]int64 {
-	timestamp := (id >> timestampShift) + epoch
-	datacenterID := (id >> datacenterIDShift) & maxDatacenterID
-	machineID := (id >> machineIDShift) & maxMachineID
-	sequence := id & maxSequence
-
-	return map[string]int64{
-		"timestamp":     timestamp,
-		"datacenter_id": datacenterID,
-		"machine_id":    machineID,
-		"sequence":      sequence,
-	}
+	return s.layout.Parse(id)
 }
 
-// GetTimestampFromID 从 ID 中提取时间戳
+// GetTimestampFromID 从 ID 中提取时间戳，使用全局实例的布局，没有初始化时使用 DefaultLayout
 func GetTimestampFromID(id int64) int64 {
-	return (id >> timestampShift) + epoch
+	return globalLayout().Timestamp(id)
 }
 
 // GetDatacenterIDFromID 从 ID 中提取数据中心ID
 func GetDatacenterIDFromID(id int64) int64 {
-	return (id >> datacenterIDShift) & maxDatacenterID
+	return globalLayout().DatacenterID(id)
 }
 
 // GetMachineIDFromID 从 ID 中提取机器ID
 func GetMachineIDFromID(id int64) int64 {
-	return (id >> machineIDShift) & maxMachineID
+	return globalLayout().MachineID(id)
 }
 
 // GetSequenceFromID 从 ID 中提取序列号
 func GetSequenceFromID(id int64) int64 {
-	return id & maxSequence
+	return globalLayout().Sequence(id)
 }
 
 // 全局 Snowflake 实例
@@ -146,15 +303,28 @@ var (
 	snowflakeOnce   sync.Once
 )
 
-// InitGlobalSnowflake 初始化全局 Snowflake 实例
+// InitGlobalSnowflake 使用 DefaultLayout 初始化全局 Snowflake 实例
 func InitGlobalSnowflake(datacenterID, machineID int64) error {
+	return InitGlobalSnowflakeWithLayout(DefaultLayout, datacenterID, machineID)
+}
+
+// InitGlobalSnowflakeWithLayout 使用指定的布局初始化全局 Snowflake 实例
+func InitGlobalSnowflakeWithLayout(layout Layout, datacenterID, machineID int64) error {
 	var err error
 	snowflakeOnce.Do(func() {
-		globalSnowflake, err = NewSnowflake(datacenterID, machineID)
+		globalSnowflake, err = NewSnowflakeWithLayout(layout, datacenterID, machineID)
 	})
 	return err
 }
 
+// globalLayout 全局实例的布局
+func globalLayout() Layout {
+	if globalSnowflake == nil {
+		return DefaultLayout
+	}
+	return globalSnowflake.layout
+}
+
 // GenerateID 使用全局实例生成 ID
 func GenerateID() (int64, error) {
 	if globalSnowflake == nil {
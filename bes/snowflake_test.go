@@ -1,6 +1,7 @@
 package bes
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"testing"
@@ -217,3 +218,114 @@ func TestSnowflakeValidation(t *testing.T) {
 
 	fmt.Println("Validation tests passed")
 }
+
+func TestSnowflakeLayout(t *testing.T) {
+	layout := Layout{TimestampBits: 41, DatacenterBits: 3, MachineBits: 8, SequenceBits: 11, Epoch: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if _, err := NewSnowflakeWithLayout(layout, 1, 256); err == nil {
+		t.Error("Expected error for machine ID out of layout")
+	}
+	snowflake, err := NewSnowflakeWithLayout(layout, 7, 200)
+	if err != nil {
+		t.Fatalf("Failed to create snowflake: %v", err)
+	}
+	id, err := snowflake.NextID()
+	if err != nil {
+		t.Fatalf("Failed to generate ID: %v", err)
+	}
+	parsed := snowflake.ParseID(id)
+	if parsed["datacenter_id"] != 7 || parsed["machine_id"] != 200 {
+		t.Errorf("Unexpected parsed ID %v", parsed)
+	}
+	if d := time.Now().UnixMilli() - parsed["timestamp"]; d < 0 || d > 1000 {
+		t.Errorf("Unexpected timestamp %d", parsed["timestamp"])
+	}
+
+	invalid := []Layout{
+		{TimestampBits: 41, DatacenterBits: 6, MachineBits: 6, SequenceBits: 12, Epoch: layout.Epoch},
+		{TimestampBits: 30, DatacenterBits: 5, MachineBits: 5, SequenceBits: 12, Epoch: layout.Epoch},
+		{TimestampBits: 41, DatacenterBits: 5, MachineBits: 5, SequenceBits: 0, Epoch: layout.Epoch},
+		{TimestampBits: 41, DatacenterBits: 5, MachineBits: 5, SequenceBits: 12, Epoch: time.Now().Add(time.Hour)},
+		{TimestampBits: 41, DatacenterBits: 5, MachineBits: 5, SequenceBits: 12},
+	}
+	for _, l := range invalid {
+		if _, err = NewSnowflakeWithLayout(l, 0, 0); err == nil {
+			t.Errorf("Expected error for layout %+v", l)
+		}
+	}
+}
+
+func TestSnowflakeClockBackwards(t *testing.T) {
+	base := time.Now().UnixMilli()
+	newFlake := func(p SnowflakeArgs, clock func() int64) *Snowflake {
+		s, err := NewSnowflakeWithArgs(p)
+		if err != nil {
+			t.Fatalf("Failed to create snowflake: %v", err)
+		}
+		s.wall = clock
+		return s
+	}
+
+	cur := base
+	reject := newFlake(SnowflakeArgs{}, func() int64 { return cur })
+	_, _ = reject.NextID()
+	cur = base - 1
+	if _, err := reject.NextID(); !errors.Is(err, ErrClockBackwards) {
+		t.Errorf("Expected ErrClockBackwards, got %v", err)
+	}
+
+	//第一次读取到回退的时间，等待后时钟追上
+	var reads []int64
+	wait := newFlake(SnowflakeArgs{Clock: ClockWait, MaxDrift: 50 * time.Millisecond}, func() int64 {
+		if len(reads) == 0 {
+			reads = append(reads, base)
+			return base
+		}
+		reads = append(reads, cur)
+		v := cur
+		cur = base + 1
+		return v
+	})
+	first, _ := wait.NextID()
+	cur = base - 5
+	if id, err := wait.NextID(); err != nil || id <= first || len(reads) != 3 {
+		t.Errorf("Expected wait for clock, got %d, %v, reads %v", id, err, reads)
+	}
+	cur = base - 100
+	if _, err := wait.NextID(); !errors.Is(err, ErrClockBackwards) {
+		t.Errorf("Expected ErrClockBackwards beyond max drift, got %v", err)
+	}
+
+	layout := DefaultLayout
+	layout.ClockSeqBits, layout.TimestampBits = 1, 40
+	if _, err := NewSnowflakeWithArgs(SnowflakeArgs{Clock: ClockSequence}); err == nil {
+		t.Error("Expected error for clock sequence without bits")
+	}
+	cur = base
+	seq := newFlake(SnowflakeArgs{Layout: layout, Clock: ClockSequence}, func() int64 { return cur })
+	a, _ := seq.NextID()
+	cur = base - 5
+	b, err := seq.NextID()
+	if err != nil || a == b || layout.ClockSequence(b) != 1 || layout.Timestamp(b) != base-5 {
+		t.Errorf("Expected borrowed clock sequence, got %v, %v", seq.ParseID(b), err)
+	}
+	//两个时钟序列号在base-3之后都用过
+	cur = base - 3
+	_, _ = seq.NextID()
+	cur = base - 4
+	if _, err = seq.NextID(); !errors.Is(err, ErrClockBackwards) {
+		t.Errorf("Expected ErrClockBackwards without free clock sequence, got %v", err)
+	}
+
+	cur = base
+	mono := newFlake(SnowflakeArgs{Clock: ClockMonotonic}, func() int64 { return cur })
+	a, _ = mono.NextID()
+	cur = base - 10000
+	b, err = mono.NextID()
+	if err != nil || b <= a || GetTimestampFromID(b) < base {
+		t.Errorf("Expected monotonic clock to ignore backwards, got %v, %v", mono.ParseID(b), err)
+	}
+	cur = base + 10000
+	if c, _ := mono.NextID(); GetTimestampFromID(c) != base+10000 {
+		t.Errorf("Expected monotonic clock to follow forward jump, got %v", mono.ParseID(c))
+	}
+}
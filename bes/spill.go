@@ -0,0 +1,368 @@
+package bes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSegmentSize = 64 * 1024 * 1024
+
+	segmentSuffix  = ".seg"
+	checkpointFile = "checkpoint"
+)
+
+// spillPos 队列中的读取位置
+type spillPos struct {
+	seg int64
+	off int64
+}
+
+// SpillQueue 分段文件的持久化队列，读取位置保存在checkpoint中，进程重启后从checkpoint继续读取
+type SpillQueue struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	read        spillPos
+	writeSeg    int64
+	writeSize   int64
+	writer      *os.File
+}
+
+// OpenSpillQueue 打开dir下的队列，不存在时创建
+func OpenSpillQueue(dir string, segmentSize int64) (*SpillQueue, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create spill dir %s failed, err:%w", dir, err)
+	}
+	q := &SpillQueue{
+		dir:         dir,
+		segmentSize: segmentSize,
+	}
+	segs, err := q.segments()
+	if err != nil {
+		return nil, err
+	}
+	if err = q.loadCheckpoint(segs); err != nil {
+		return nil, err
+	}
+	if len(segs) > 0 {
+		q.writeSeg = segs[len(segs)-1]
+	} else {
+		q.writeSeg = q.read.seg
+	}
+	if err = q.openWriter(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *SpillQueue) segmentPath(seg int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%016d%s", seg, segmentSuffix))
+}
+
+// segments 按序号返回所有分段
+func (q *SpillQueue) segments() ([]int64, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	segs := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seg, err := strconv.ParseInt(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+func (q *SpillQueue) loadCheckpoint(segs []int64) error {
+	b, err := os.ReadFile(filepath.Join(q.dir, checkpointFile))
+	if os.IsNotExist(err) {
+		if len(segs) > 0 {
+			q.read = spillPos{seg: segs[0]}
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read spill checkpoint failed, err:%w", err)
+	}
+	if _, err = fmt.Sscanf(string(b), "%d %d", &q.read.seg, &q.read.off); err != nil {
+		return fmt.Errorf("parse spill checkpoint %q failed, err:%w", b, err)
+	}
+	return nil
+}
+
+// openWriter 打开写入的分段，截掉上次崩溃时写了一半的数据
+func (q *SpillQueue) openWriter() error {
+	path := q.segmentPath(q.writeSeg)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open spill segment %s failed, err:%w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	size, err := lineEnd(f, info.Size())
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if size != info.Size() {
+		if err = f.Truncate(size); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+	q.writer = f
+	q.writeSize = size
+	return nil
+}
+
+// lineEnd 从文件末尾向前查找最后一个换行符，返回完整数据的长度
+func lineEnd(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		b := buf[:end-start]
+		if _, err := f.ReadAt(b, start); err != nil {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// Push 追加数据，写满一个分段后切换到新分段
+func (q *SpillQueue) Push(items ...EsData) error {
+	var buf bytes.Buffer
+	for _, item := range items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return fmt.Errorf("marshal spill data %+v failed, err:%w", item.Data, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n, err := q.writer.Write(buf.Bytes())
+	q.writeSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("write spill segment failed, err:%w", err)
+	}
+	if err = q.writer.Sync(); err != nil {
+		return err
+	}
+	if q.writeSize >= q.segmentSize {
+		if err = q.writer.Close(); err != nil {
+			return err
+		}
+		q.writeSeg++
+		return q.openWriter()
+	}
+	return nil
+}
+
+// Empty 没有未读取的数据
+func (q *SpillQueue) Empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.read.seg == q.writeSeg && q.read.off >= q.writeSize
+}
+
+// peek 从读取位置开始最多读取n条数据，返回数据和读完后的位置，不移动读取位置
+func (q *SpillQueue) peek(n int) ([]EsData, spillPos, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pos := q.read
+	items := make([]EsData, 0, n)
+	for len(items) < n {
+		end := q.writeSize
+		if pos.seg != q.writeSeg {
+			info, err := os.Stat(q.segmentPath(pos.seg))
+			if err != nil {
+				if os.IsNotExist(err) && pos.seg < q.writeSeg {
+					pos = spillPos{seg: pos.seg + 1}
+					continue
+				}
+				return items, pos, err
+			}
+			end = info.Size()
+		}
+		if pos.off >= end {
+			if pos.seg >= q.writeSeg {
+				break
+			}
+			pos = spillPos{seg: pos.seg + 1}
+			continue
+		}
+		read, next, err := q.readSegment(pos, end, n-len(items))
+		items = append(items, read...)
+		if err != nil {
+			return items, next, err
+		}
+		if next == pos {
+			break
+		}
+		pos = next
+	}
+	return items, pos, nil
+}
+
+func (q *SpillQueue) readSegment(pos spillPos, end int64, n int) ([]EsData, spillPos, error) {
+	f, err := os.Open(q.segmentPath(pos.seg))
+	if err != nil {
+		return nil, pos, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(io.NewSectionReader(f, pos.off, end-pos.off))
+	items := make([]EsData, 0, n)
+	for len(items) < n {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return items, pos, err
+		}
+		pos.off += int64(len(line))
+		var rec rawEsData
+		if err = json.Unmarshal(line, &rec); err != nil {
+			//跳过损坏的数据
+			continue
+		}
+		items = append(items, rec.esData())
+	}
+	return items, pos, nil
+}
+
+// ack 保存读取位置，并删除已经读完的分段
+func (q *SpillQueue) ack(pos spillPos) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	tmp := filepath.Join(q.dir, checkpointFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", pos.seg, pos.off)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, checkpointFile)); err != nil {
+		return err
+	}
+	for seg := q.read.seg; seg < pos.seg; seg++ {
+		_ = os.Remove(q.segmentPath(seg))
+	}
+	q.read = pos
+	return nil
+}
+
+func (q *SpillQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.writer.Close()
+}
+
+// dispatch 把batch交给worker，es不可用或worker都在忙时写入磁盘队列
+func (s *BatES) dispatch(batch *esBatch, callback ErrorCallback) {
+	if s.spill == nil {
+		_ = s.queue.push(context.Background(), batch)
+		return
+	}
+	if atomic.LoadInt32(&s.unhealthy) == 0 && s.queue.tryPush(batch) {
+		return
+	}
+	if err := s.spillBatch(batch); err != nil {
+		callback(err)
+		_ = s.queue.push(context.Background(), batch)
+	}
+}
+
+// spillBatch 把batch中的数据写入磁盘队列
+func (s *BatES) spillBatch(batch *esBatch) error {
+	items := batch.docs()
+	if err := s.spill.Push(items...); err != nil {
+		return fmt.Errorf("spill %d documents failed, err:%s", len(items), err)
+	}
+	s.release(batch)
+	return nil
+}
+
+// replay 按顺序重新提交磁盘队列中的数据，提交成功后保存读取位置
+func (s *BatES) replay(ctx context.Context, callback ErrorCallback) {
+	defer close(s.replayDone)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	attempts := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for ctx.Err() == nil && !s.spill.Empty() {
+			items, pos, err := s.spill.peek(s.batchSize)
+			if err != nil {
+				callback(fmt.Errorf("read spill queue failed, err:%s", err))
+				break
+			}
+			if len(items) > 0 {
+				//磁盘队列中的数据使用默认通道的优先级
+				batch := s.newBatch(0)
+				batch.done = make(chan bool, 1)
+				for _, item := range items {
+					s.add(batch, item, false)
+				}
+				if err = s.queue.push(ctx, batch); err != nil {
+					s.release(batch)
+					return
+				}
+				if ok := <-batch.done; !ok {
+					attempts++
+					wait, _ := s.backoff.Next(attempts)
+					sleep(ctx, wait)
+					break
+				}
+				attempts = 0
+			}
+			if err = s.spill.ack(pos); err != nil {
+				callback(fmt.Errorf("save spill checkpoint failed, err:%s", err))
+				break
+			}
+			if len(items) == 0 {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package bes
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpillQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenSpillQueue(dir, 256)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	if !q.Empty() {
+		t.Fatal("Expected new queue to be empty")
+	}
+	for i := 0; i < 20; i++ {
+		if err = q.Push(EsData{Id: string(rune('a' + i)), Index: "log", Data: map[string]int{"i": i}}); err != nil {
+			t.Fatalf("Failed to push: %v", err)
+		}
+	}
+	segs, _ := q.segments()
+	if len(segs) < 2 {
+		t.Errorf("Expected multiple segments, got %d", len(segs))
+	}
+
+	items, pos, err := q.peek(15)
+	if err != nil || len(items) != 15 {
+		t.Fatalf("Expected 15 items, got %d, err: %v", len(items), err)
+	}
+	if items[0].Id != "a" || items[14].Id != "o" {
+		t.Errorf("Unexpected order: %s ... %s", items[0].Id, items[14].Id)
+	}
+	if err = q.ack(pos); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	q.Close()
+
+	//重新打开后从checkpoint继续读取
+	q, err = OpenSpillQueue(dir, 256)
+	if err != nil {
+		t.Fatalf("Failed to reopen spill queue: %v", err)
+	}
+	defer q.Close()
+	items, pos, err = q.peek(100)
+	if err != nil || len(items) != 5 {
+		t.Fatalf("Expected 5 remaining items, got %d, err: %v", len(items), err)
+	}
+	if items[0].Id != "p" {
+		t.Errorf("Expected to resume at p, got %s", items[0].Id)
+	}
+	if err = q.ack(pos); err != nil {
+		t.Fatalf("Failed to ack: %v", err)
+	}
+	if !q.Empty() {
+		t.Error("Expected queue to be empty")
+	}
+	if _, err = os.Stat(q.segmentPath(segs[0])); !os.IsNotExist(err) {
+		t.Error("Expected consumed segment to be removed")
+	}
+}
+
+func TestSpillOnStopCancel(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	defer q.Close()
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: &hangSink{}, Snowflake: sf, Spill: q, Retry: 3, FlushInterval: time.Hour})
+	for i := 0; i < 5; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if dropped, _ := es.Stop(ctx); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	//取消的请求写入磁盘队列，重启后继续提交
+	if items, _, err := q.peek(10); err != nil || len(items) != 5 {
+		t.Errorf("Expected 5 documents spilled on stop, got %d, %v", len(items), err)
+	}
+}
+
+func TestBatESSpillReplay(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	defer q.Close()
+	sink := &flakySink{fails: 5, counts: map[string]int{}}
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{
+		Sink:          sink,
+		Snowflake:     sf,
+		Spill:         q,
+		BatchSize:     5,
+		Backoff:       NewBackoff(time.Millisecond, time.Millisecond, 0),
+		FlushInterval: 5 * time.Millisecond,
+	})
+	for i := 0; i < 30; i++ {
+		_ = es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"i": i}})
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for (!q.Empty() || es.Stats().Indexed < 30) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if dropped, _ := es.Stop(ctx); dropped != 0 {
+		t.Fatalf("Expected nothing dropped, got %d", dropped)
+	}
+	if !q.Empty() || sink.fails != 0 {
+		t.Errorf("Expected spill queue drained after %d failures left", sink.fails)
+	}
+	if len(sink.counts) != 30 {
+		t.Fatalf("Expected 30 documents, got %d", len(sink.counts))
+	}
+	for id, n := range sink.counts {
+		if n != 1 {
+			t.Errorf("Expected id %s written once, got %d", id, n)
+		}
+	}
+}
+
+func TestSpillQueueTruncatePartial(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenSpillQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	//数据比查找换行符的缓冲区大
+	big := strings.Repeat("x", 10000)
+	if err = q.Push(EsData{Id: "a", Index: "log", Data: map[string]string{"msg": big}}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	path := q.segmentPath(q.writeSeg)
+	q.Close()
+	//模拟崩溃时写了一半的数据
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	_, _ = f.WriteString(`{"Id":"b","Index":"log","Data":{"msg":"` + big)
+	f.Close()
+
+	q, err = OpenSpillQueue(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("Failed to reopen spill queue: %v", err)
+	}
+	defer q.Close()
+	if err = q.Push(EsData{Id: "c", Index: "log"}); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	items, _, err := q.peek(10)
+	if err != nil || len(items) != 2 || items[0].Id != "a" || items[1].Id != "c" {
+		t.Fatalf("Expected a and c after truncating partial line, got %+v, err: %v", items, err)
+	}
+}
@@ -0,0 +1,207 @@
+package bes
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrFull 队列已满
+	ErrFull = errors.New("bes: input queue is full")
+	// ErrStopped 输出器已停止
+	ErrStopped = errors.New("bes: es output is stopped")
+	// ErrShed 通道压力大，数据被丢弃
+	ErrShed = errors.New("bes: lane is shedding load")
+)
+
+// OverflowPolicy 队列满时的处理方式
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞直到有空间或ctx结束，默认
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest 丢弃新数据
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃队列中最早的数据
+	OverflowDropOldest
+	// OverflowError 返回 ErrFull
+	OverflowError
+	// OverflowSpill 写入磁盘队列，没有配置 Spill 时同 OverflowBlock
+	OverflowSpill
+)
+
+// SubmitStats 提交数据的计数
+type SubmitStats struct {
+	// Accepted 进入队列的条数
+	Accepted int64
+	// Spilled 队列满时写入磁盘的条数
+	Spilled int64
+	// Dropped 队列满时丢弃的条数
+	Dropped int64
+	// Rejected 返回错误的条数
+	Rejected int64
+	// Shed 通道压力大时丢弃的条数
+	Shed int64
+}
+
+// Submit 提交数据到索引对应的通道，队列满时按 OverflowPolicy 处理
+// 阻塞等待时ctx结束返回ctx.Err()，Stop时返回 ErrStopped，通道丢弃数据时返回 ErrShed
+func (s *BatES) Submit(ctx context.Context, data EsData) error {
+	input, err := s.offer(data)
+	if input == nil {
+		return err
+	}
+	defer s.submitting.Done()
+	select {
+	case input <- data:
+		atomic.AddInt64(&s.submitStats.Accepted, 1)
+		return nil
+	case <-s.closing:
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return ErrStopped
+	case <-ctx.Done():
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return ctx.Err()
+	}
+}
+
+// offer 在锁内完成不需要等待的提交，需要阻塞等待时返回数据所在的队列
+// 等待时不持有锁，由submitting让closeInput等待提交返回
+func (s *BatES) offer(data EsData) (chan EsData, error) {
+	s.inputMu.RLock()
+	defer s.inputMu.RUnlock()
+	if s.closed {
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return nil, ErrStopped
+	}
+	input, ok := s.laneInput(data)
+	if !ok {
+		return nil, ErrShed
+	}
+	select {
+	case input <- data:
+		atomic.AddInt64(&s.submitStats.Accepted, 1)
+		return nil, nil
+	default:
+	}
+	switch s.overflow {
+	case OverflowDropNewest:
+		atomic.AddInt64(&s.submitStats.Dropped, 1)
+		return nil, nil
+	case OverflowDropOldest:
+		s.pushOut(input, data)
+		return nil, nil
+	case OverflowError:
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return nil, ErrFull
+	case OverflowSpill:
+		if s.spill != nil {
+			return nil, s.spillOne(data)
+		}
+	}
+	s.submitting.Add(1)
+	return input, nil
+}
+
+// TrySubmit 不阻塞地提交数据，数据进入队列或磁盘队列时返回true
+func (s *BatES) TrySubmit(data EsData) bool {
+	s.inputMu.RLock()
+	defer s.inputMu.RUnlock()
+	if s.closed {
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return false
+	}
+	input, ok := s.laneInput(data)
+	if !ok {
+		return false
+	}
+	select {
+	case input <- data:
+		atomic.AddInt64(&s.submitStats.Accepted, 1)
+		return true
+	default:
+	}
+	switch s.overflow {
+	case OverflowDropNewest:
+		atomic.AddInt64(&s.submitStats.Dropped, 1)
+		return false
+	case OverflowDropOldest:
+		s.pushOut(input, data)
+		return true
+	case OverflowSpill:
+		if s.spill != nil {
+			return s.spillOne(data) == nil
+		}
+	}
+	atomic.AddInt64(&s.submitStats.Rejected, 1)
+	return false
+}
+
+// SubmitStats 返回提交数据的计数
+func (s *BatES) SubmitStats() SubmitStats {
+	return SubmitStats{
+		Accepted: atomic.LoadInt64(&s.submitStats.Accepted),
+		Spilled:  atomic.LoadInt64(&s.submitStats.Spilled),
+		Dropped:  atomic.LoadInt64(&s.submitStats.Dropped),
+		Rejected: atomic.LoadInt64(&s.submitStats.Rejected),
+		Shed:     atomic.LoadInt64(&s.submitStats.Shed),
+	}
+}
+
+// laneInput 返回数据所在通道的队列，通道压力大丢弃数据时返回false
+func (s *BatES) laneInput(data EsData) (chan EsData, bool) {
+	l := s.route(data.Index)
+	if l == nil {
+		return s.input, true
+	}
+	if s.shedding(l) {
+		atomic.AddInt64(&l.shed, 1)
+		atomic.AddInt64(&s.submitStats.Shed, 1)
+		return nil, false
+	}
+	return l.input, true
+}
+
+// pushOut 丢弃队列中最早的数据，直到新数据进入队列
+func (s *BatES) pushOut(input chan EsData, data EsData) {
+	for {
+		select {
+		case input <- data:
+			atomic.AddInt64(&s.submitStats.Accepted, 1)
+			return
+		default:
+		}
+		select {
+		case <-input:
+			atomic.AddInt64(&s.submitStats.Dropped, 1)
+		default:
+		}
+	}
+}
+
+// spillOne 写入磁盘队列前生成id，重新提交部分成功或进程重启时不会产生新的id
+func (s *BatES) spillOne(data EsData) error {
+	if _, err := s.prepare(&data); err != nil {
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return err
+	}
+	if err := s.spill.Push(data); err != nil {
+		atomic.AddInt64(&s.submitStats.Rejected, 1)
+		return err
+	}
+	atomic.AddInt64(&s.submitStats.Spilled, 1)
+	if s.mirror != nil {
+		s.mirrorItem(data)
+	}
+	return nil
+}
+
+// closeInput 停止接收数据，等待正在提交的调用返回，阻塞等待的调用返回 ErrStopped
+func (s *BatES) closeInput() {
+	s.inputMu.Lock()
+	s.closed = true
+	close(s.closing)
+	s.inputMu.Unlock()
+	s.submitting.Wait()
+}
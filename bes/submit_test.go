@@ -0,0 +1,98 @@
+package bes
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubmitOverflow(t *testing.T) {
+	cases := []struct {
+		policy   OverflowPolicy
+		err      error
+		accepted int64
+		dropped  int64
+		rejected int64
+		first    string
+	}{
+		{OverflowDropNewest, nil, 2, 1, 0, "0"},
+		{OverflowDropOldest, nil, 3, 1, 0, "1"},
+		{OverflowError, ErrFull, 2, 0, 1, "0"},
+		{OverflowBlock, context.DeadlineExceeded, 2, 0, 1, "0"},
+	}
+	for _, c := range cases {
+		s := &BatES{input: make(chan EsData, 2), overflow: c.policy, closing: make(chan struct{})}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		var err error
+		for _, id := range []string{"0", "1", "2"} {
+			err = s.Submit(ctx, EsData{Id: id})
+		}
+		cancel()
+		if err != c.err {
+			t.Errorf("policy %d: expected err %v, got %v", c.policy, c.err, err)
+		}
+		stats := s.SubmitStats()
+		if stats.Accepted != c.accepted || stats.Dropped != c.dropped || stats.Rejected != c.rejected {
+			t.Errorf("policy %d: unexpected stats %+v", c.policy, stats)
+		}
+		if first := <-s.input; first.Id != c.first {
+			t.Errorf("policy %d: expected first item %s, got %s", c.policy, c.first, first.Id)
+		}
+	}
+}
+
+func TestSubmitSpillAndStop(t *testing.T) {
+	q, err := OpenSpillQueue(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Failed to open spill queue: %v", err)
+	}
+	defer q.Close()
+	sf, _ := NewSnowflake(1, 1)
+	s := &BatES{input: make(chan EsData, 1), overflow: OverflowSpill, spill: q, closing: make(chan struct{}), Snowflake: sf}
+	if !s.TrySubmit(EsData{Id: "0"}) || !s.TrySubmit(EsData{Id: "1"}) || !s.TrySubmit(EsData{Index: "log"}) {
+		t.Fatal("Expected all items accepted")
+	}
+	if stats := s.SubmitStats(); stats.Accepted != 1 || stats.Spilled != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	//写入磁盘前已经生成id
+	if items, _, err := q.peek(10); err != nil || len(items) != 2 || items[0].Id != "1" || items[1].Id == "" {
+		t.Errorf("Expected spilled items with ids, got %+v, %v", items, err)
+	}
+	s.closeInput()
+	if err = s.Submit(context.Background(), EsData{Id: "2"}); err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+}
+
+func TestStopWithBlockedSubmit(t *testing.T) {
+	sf, _ := NewSnowflake(1, 1)
+	es := NewBatES(BesArgs{Sink: &hangSink{}, Snowflake: sf, ChannelSize: 1, BatchSize: 1})
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			if err := es.Submit(context.Background(), EsData{Index: "log", Data: map[string]int{"a": 1}}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	//等待队列和提交中的位置都占满
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, _ = es.Stop(ctx)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Expected stop soon after ctx deadline, took %s", d)
+	}
+	select {
+	case err := <-errs:
+		if err != ErrStopped {
+			t.Errorf("Expected blocked submit to return ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected blocked submit to return")
+	}
+}
@@ -10,3 +10,5 @@ require (
 	github.com/olivere/elastic/v7 v7.0.32 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 )
+
+replace github.com/dog-xyz/utils/bes => ../bes
@@ -1,6 +1,7 @@
 package gcl
 
 import (
+    "context"
     "encoding/json"
     "fmt"
     "os"
@@ -32,6 +33,7 @@ type LogEntry struct {
 // Logger 日志器
 type Logger struct {
 	logIndexPrefix string
+	index          *bes.IndexFormatter
     serverName string
 	batES      *bes.BatES
 }
@@ -40,6 +42,7 @@ type Logger struct {
 func NewGCLogger(logIndexPrefix string, serverName string, batES *bes.BatES) *Logger {
     return &Logger{
         logIndexPrefix: logIndexPrefix,
+		index:          bes.NewIndexFormatter(logIndexPrefix, bes.PeriodDaily, time.Local),
         serverName: serverName,
 		batES:      batES,
     }
@@ -107,11 +110,13 @@ func (l *Logger) Infof(quickId string, format string, args ...interface{}) {
 		return
 	}
 	fmt.Println(string(jsonData))
-    date := time.Now().Format("2006-01-02")
     if l.batES != nil {
-        l.batES.Input <- bes.EsData{
-            Index: l.logIndexPrefix + "_" + date,
+        err = l.batES.Submit(context.Background(), bes.EsData{
+            Index: l.index.Format(entry.Timestamp),
             Data: entry,
+        })
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "submit log failed: %v\n", err)
         }
     }
 }
@@ -133,11 +138,13 @@ func (l *Logger) Errorf(quickId string, format string, args ...interface{}) {
 		return
 	}
 	fmt.Println(string(jsonData))
-    date := time.Now().Format("2006-01-02")
     if l.batES != nil {
-        l.batES.Input <- bes.EsData{
-            Index: l.logIndexPrefix + "_" + date,
+        err = l.batES.Submit(context.Background(), bes.EsData{
+            Index: l.index.Format(entry.Timestamp),
             Data: entry,
+        })
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "submit log failed: %v\n", err)
         }
     }
 }
\ No newline at end of file
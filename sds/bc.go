@@ -2,6 +2,7 @@ package sds
 
 import (
 	crand "crypto/rand"
+	"errors"
 	"math"
 	"math/big"
 	mrand "math/rand"
@@ -15,22 +16,19 @@ type BigCache struct {
 	num    uint32
 	mus    []sync.Mutex
 	shards []*fasterCache
+	//可选的过滤器，GetOrLoad 用于跳过一定不存在的key
+	filter *CountingBloom
 }
 
+// ErrNotFound GetOrLoad 加载的key不存在
+var ErrNotFound = errors.New("sds: key not found")
+
+// LoaderFunc GetOrLoad 未命中时加载key的值
+type LoaderFunc func(key string) (interface{}, error)
+
 func NewBigCache(mode int, num uint32, size int, onEvict EvictFunc) *BigCache {
-	//generate a seed, used for djb33
-	var seed uint32
-	max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
-	rnd, err := crand.Int(crand.Reader, max)
-	if err != nil {
-		_, _ = os.Stderr.Write([]byte("\n"))
-		seed = mrand.Uint32()
-	} else {
-		seed = uint32(rnd.Uint64())
-	}
-	//
 	hc := &BigCache{
-		seed:   seed,
+		seed:   newSeed(),
 		num:    num,
 		mus:    make([]sync.Mutex, num),
 		shards: make([]*fasterCache, num),
@@ -42,6 +40,23 @@ func NewBigCache(mode int, num uint32, size int, onEvict EvictFunc) *BigCache {
 	return hc
 }
 
+// generate a seed, used for djb33
+func newSeed() uint32 {
+	max := big.NewInt(0).SetUint64(uint64(math.MaxUint32))
+	rnd, err := crand.Int(crand.Reader, max)
+	if err != nil {
+		_, _ = os.Stderr.Write([]byte("\n"))
+		return mrand.Uint32()
+	}
+	return uint32(rnd.Uint64())
+}
+
+// SetFilter 设置过滤器，需要在使用 GetOrLoad 前调用
+// 过滤器中需要包含所有存在的key，Test 返回false的key不会调用loader
+func (f *BigCache) SetFilter(filter *CountingBloom) {
+	f.filter = filter
+}
+
 func (f *BigCache) idx(k string) uint32 {
 	return djb33(f.seed, k) % f.num
 }
@@ -52,6 +67,7 @@ func (f *BigCache) Set(key string, value interface{}, expiration time.Duration)
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
 	f.shards[i].set(key, value, expiration)
+	f.shards[i].touch(key)
 }
 
 func (f *BigCache) Get(key string) interface{} {
@@ -61,6 +77,25 @@ func (f *BigCache) Get(key string) interface{} {
 	return f.shards[i].get(key)
 }
 
+// GetOrLoad 未命中时调用loader加载并缓存，loader在锁外执行
+func (f *BigCache) GetOrLoad(key string, expiration time.Duration, loader LoaderFunc) (interface{}, error) {
+	if v := f.Get(key); v != nil {
+		return v, nil
+	}
+	if f.filter != nil && !f.filter.Test(key) {
+		return nil, ErrNotFound
+	}
+	v, err := loader(key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	f.Set(key, v, expiration)
+	return v, nil
+}
+
 func (f *BigCache) DataType(key string) int {
 	i := f.idx(key)
 	f.mus[i].Lock()
@@ -73,6 +108,7 @@ func (f *BigCache) Del(key string) {
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
 	f.shards[i].del(key)
+	f.shards[i].touch(key)
 }
 
 func (f *BigCache) Exist(key string) bool {
@@ -107,7 +143,9 @@ func (f *BigCache) IncrBy(key string, incr int64) int64 {
 	i := f.idx(key)
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
-	return f.shards[i].incrBy(key, incr)
+	v := f.shards[i].incrBy(key, incr)
+	f.shards[i].touch(key)
+	return v
 }
 
 func (f *BigCache) GetTTL(key string) int64 {
@@ -122,6 +160,7 @@ func (f *BigCache) Expire(key string, expiration time.Duration) {
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
 	f.shards[i].expire(key, expiration)
+	f.shards[i].touch(key)
 }
 
 func (f *BigCache) HSet(key, subKey string, value interface{}, expiration time.Duration) {
@@ -129,6 +168,7 @@ func (f *BigCache) HSet(key, subKey string, value interface{}, expiration time.D
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
 	f.shards[i].hSet(key, subKey, value, expiration)
+	f.shards[i].touch(key)
 }
 
 func (f *BigCache) HGet(key, subKey string) interface{} {
@@ -150,13 +190,46 @@ func (f *BigCache) HDel(key, subKey string) {
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
 	f.shards[i].hDel(key, subKey)
+	f.shards[i].touch(key)
 }
 
+// HGetAll 返回hash的拷贝，调用方可以在锁外安全遍历
 func (f *BigCache) HGetAll(key string) map[string]interface{} {
 	i := f.idx(key)
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
-	return f.shards[i].hGetAll(key)
+	hm := f.shards[i].hGetAll(key)
+	if hm == nil {
+		return nil
+	}
+	snapshot := make(map[string]interface{}, len(hm))
+	for k, v := range hm {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// HGetAllFunc 在分片锁内以内部hash调用fn，不拷贝；fn不能保留或修改map，也不能调用BigCache的方法
+func (f *BigCache) HGetAllFunc(key string, fn func(hm map[string]interface{})) {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	fn(f.shards[i].hGetAll(key))
+}
+
+func (f *BigCache) HMSet(key string, values map[string]interface{}, expiration time.Duration) {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	f.shards[i].hMSet(key, values, expiration)
+	f.shards[i].touch(key)
+}
+
+func (f *BigCache) HMGet(key string, subKeys ...string) []interface{} {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	return f.shards[i].hMGet(key, subKeys...)
 }
 
 func (f *BigCache) HLen(key string) int {
@@ -177,7 +250,101 @@ func (f *BigCache) HIncrBy(key, subKey string, incr int64, expiration time.Durat
 	i := f.idx(key)
 	f.mus[i].Lock()
 	defer f.mus[i].Unlock()
-	return f.shards[i].hIncrBy(key, subKey, incr, expiration)
+	v := f.shards[i].hIncrBy(key, subKey, incr, expiration)
+	f.shards[i].touch(key)
+	return v
+}
+
+func (f *BigCache) PFAdd(key string, expiration time.Duration, elements ...string) bool {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	changed := f.shards[i].pfAdd(key, elements, expiration)
+	if changed {
+		f.shards[i].touch(key)
+	}
+	return changed
+}
+
+// PFCount 多个key时返回合并后的基数估计
+func (f *BigCache) PFCount(keys ...string) int64 {
+	if len(keys) == 1 {
+		i := f.idx(keys[0])
+		f.mus[i].Lock()
+		defer f.mus[i].Unlock()
+		return f.shards[i].pfCount(keys[0])
+	}
+	var merged *hyperLogLog
+	for _, key := range keys {
+		i := f.idx(key)
+		f.mus[i].Lock()
+		hll := f.shards[i].pfClone(key)
+		f.mus[i].Unlock()
+		if hll == nil {
+			continue
+		}
+		if merged == nil {
+			merged = hll
+		} else {
+			merged.merge(hll)
+		}
+	}
+	if merged == nil {
+		return 0
+	}
+	return merged.count()
+}
+
+// PFMerge 把srcs合并到dest，dest已存在时保留原有数据
+func (f *BigCache) PFMerge(dest string, expiration time.Duration, srcs ...string) {
+	hlls := make([]*hyperLogLog, 0, len(srcs))
+	for _, key := range srcs {
+		i := f.idx(key)
+		f.mus[i].Lock()
+		hll := f.shards[i].pfClone(key)
+		f.mus[i].Unlock()
+		if hll != nil {
+			hlls = append(hlls, hll)
+		}
+	}
+	i := f.idx(dest)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	f.shards[i].pfMerge(dest, hlls, expiration)
+	f.shards[i].touch(dest)
+}
+
+// CMSInit 按指定的宽度、深度和topK创建count-min sketch，CMSIncrBy 对不存在的key使用默认参数
+func (f *BigCache) CMSInit(key string, width, depth uint32, topK int, expiration time.Duration) {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	f.shards[i].cmsInit(key, width, depth, topK, expiration)
+	f.shards[i].touch(key)
+}
+
+func (f *BigCache) CMSIncrBy(key, item string, incr int64, expiration time.Duration) int64 {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	v := f.shards[i].cmsIncrBy(key, item, incr, expiration)
+	f.shards[i].touch(key)
+	return v
+}
+
+func (f *BigCache) CMSQuery(key string, items ...string) []int64 {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	return f.shards[i].cmsQuery(key, items)
+}
+
+// CMSTopK 按估计值从大到小返回高频元素
+func (f *BigCache) CMSTopK(key string) []HeavyHitter {
+	i := f.idx(key)
+	f.mus[i].Lock()
+	defer f.mus[i].Unlock()
+	return f.shards[i].cmsTopK(key)
 }
 
 // djb2 with better shuffling. 5x BigCache than FNV with the hash.Hash overhead.
@@ -12,17 +12,22 @@ const (
 
 	typeKv   = 0
 	typeHash = 1
+	typeHLL  = 2
+	typeCMS  = 3
+	typeWin  = 4
 )
 
 type entry struct {
 	expiration int64
 	key        string
-	//data type, 0: value, 1: hash map
+	//data type, 0: value, 1: hash map, 2: hyperloglog, 3: count-min sketch, 4: rate limit window
 	dataType int
-	//key value type
+	//key value type, hyperloglog/count-min sketch/限流窗口 也存放在value中
 	value interface{}
 	//hash map type
 	hashMap map[string]interface{}
+	//修改版本号，用于事务 watch
+	version uint64
 }
 
 type EvictFunc func(key interface{}, value interface{})
@@ -36,6 +41,8 @@ type fasterCache struct {
 	//data store map
 	dataMap map[string]*list.Element
 	onEvict EvictFunc
+	//分片内递增的修改序号
+	seq uint64
 }
 
 func NewFasterCache(mode int, size int, onEvict EvictFunc) *fasterCache {
@@ -456,6 +463,22 @@ func (fc *fasterCache) hGetAll(key string) map[string]interface{} {
 	return nil
 }
 
+// hmset key subkey value ...
+func (fc *fasterCache) hMSet(key string, values map[string]interface{}, expiration time.Duration) {
+	for subKey, value := range values {
+		fc.hSet(key, subKey, value, expiration)
+	}
+}
+
+// hmget key subkey ...
+func (fc *fasterCache) hMGet(key string, subKeys ...string) []interface{} {
+	values := make([]interface{}, len(subKeys))
+	for i, subKey := range subKeys {
+		values[i] = fc.hGet(key, subKey)
+	}
+	return values
+}
+
 // hlen key
 func (fc *fasterCache) hLen(key string) int {
 	//判断key是否存在
@@ -605,6 +628,198 @@ func (fc *fasterCache) hIncrBy(key, subKey string, incr int64, expiration time.D
 	return value
 }
 
+// liveEntry 返回未过期且类型一致的entry，过期则删除
+func (fc *fasterCache) liveEntry(key string, dataType int) *entry {
+	if e, ok := fc.dataMap[key]; ok {
+		ent := e.Value.(*entry)
+		if ent.dataType != dataType {
+			return nil
+		}
+		if ent.expiration >= time.Now().UnixNano() {
+			if fc.mode == modeLru {
+				fc.evictList.MoveToFront(e)
+			}
+			return ent
+		}
+		//如果过期，删除key
+		fc.removeElement(e)
+	}
+	return nil
+}
+
+// writableEntry 返回可写的entry，key不存在、已过期或类型不一致时用newValue新建
+func (fc *fasterCache) writableEntry(key string, dataType int, expiration time.Duration, newValue func() interface{}) *entry {
+	if e, ok := fc.dataMap[key]; ok {
+		ent := e.Value.(*entry)
+		if ent.dataType == dataType && ent.expiration >= time.Now().UnixNano() {
+			if expiration > 0 {
+				//如果设置了新的过期时间，更新过期时间
+				ent.expiration = time.Now().Add(expiration).UnixNano()
+			}
+			if fc.mode == modeLru {
+				fc.evictList.MoveToFront(e)
+			}
+			return ent
+		}
+		fc.removeElement(e)
+	}
+	//如果没有设置过期时间，使用默认过期时间
+	if expiration <= 0 {
+		expiration = defaultExpire
+	}
+	ent := &entry{
+		dataType:   dataType,
+		expiration: time.Now().Add(expiration).UnixNano(),
+		key:        key,
+		value:      newValue(),
+	}
+	fc.dataMap[key] = fc.evictList.PushFront(ent)
+	if fc.evictList.Len() > fc.size {
+		fc.removeTail()
+	}
+	return ent
+}
+
+//hyperloglog
+
+// pfadd key element ..., 基数估计有变化返回true
+func (fc *fasterCache) pfAdd(key string, elements []string, expiration time.Duration) bool {
+	if key == "" {
+		return false
+	}
+	_, existed := fc.version(key)
+	ent := fc.writableEntry(key, typeHLL, expiration, func() interface{} { return newHyperLogLog() })
+	hll := ent.value.(*hyperLogLog)
+	changed := !existed
+	for _, el := range elements {
+		if hll.add(el) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// pfclone key, 返回hyperloglog的拷贝，用于跨分片合并
+func (fc *fasterCache) pfClone(key string) *hyperLogLog {
+	if ent := fc.liveEntry(key, typeHLL); ent != nil {
+		return ent.value.(*hyperLogLog).clone()
+	}
+	return nil
+}
+
+// pfcount key
+func (fc *fasterCache) pfCount(key string) int64 {
+	if ent := fc.liveEntry(key, typeHLL); ent != nil {
+		return ent.value.(*hyperLogLog).count()
+	}
+	return 0
+}
+
+// pfmerge key, 把srcs合并到key
+func (fc *fasterCache) pfMerge(key string, srcs []*hyperLogLog, expiration time.Duration) {
+	if key == "" {
+		return
+	}
+	ent := fc.writableEntry(key, typeHLL, expiration, func() interface{} { return newHyperLogLog() })
+	hll := ent.value.(*hyperLogLog)
+	for _, src := range srcs {
+		hll.merge(src)
+	}
+}
+
+//count-min sketch
+
+// cmsinit key width depth topk, key已存在时重建
+func (fc *fasterCache) cmsInit(key string, width, depth uint32, topK int, expiration time.Duration) {
+	if key == "" {
+		return
+	}
+	ent := fc.writableEntry(key, typeCMS, expiration, func() interface{} { return nil })
+	ent.value = newCountMinSketch(width, depth, topK)
+}
+
+// cmsincrby key item incr, 返回增加后的估计值
+func (fc *fasterCache) cmsIncrBy(key, item string, incr int64, expiration time.Duration) int64 {
+	if key == "" {
+		return 0
+	}
+	ent := fc.writableEntry(key, typeCMS, expiration, func() interface{} {
+		return newCountMinSketch(defaultCMSWidth, defaultCMSDepth, defaultCMSTopK)
+	})
+	return ent.value.(*countMinSketch).incrBy(item, incr)
+}
+
+// cmsquery key item ...
+func (fc *fasterCache) cmsQuery(key string, items []string) []int64 {
+	counts := make([]int64, len(items))
+	if ent := fc.liveEntry(key, typeCMS); ent != nil {
+		cms := ent.value.(*countMinSketch)
+		for i, item := range items {
+			counts[i] = cms.query(item)
+		}
+	}
+	return counts
+}
+
+// cmstopk key
+func (fc *fasterCache) cmsTopK(key string) []HeavyHitter {
+	if ent := fc.liveEntry(key, typeCMS); ent != nil {
+		return ent.value.(*countMinSketch).list()
+	}
+	return nil
+}
+
+//rate limit
+
+// windowAllowN key, 在限流窗口中申请n个配额，每次调用刷新过期时间
+func (fc *fasterCache) windowAllowN(key string, algo, limit int, window time.Duration, n int) bool {
+	if key == "" {
+		return false
+	}
+	now := time.Now().UnixNano()
+	//滑动窗口计数需要保留上一窗口
+	expiration := window
+	if algo == LimitSlidingWindow {
+		expiration = 2 * window
+	}
+	ent := fc.writableEntry(key, typeWin, expiration, func() interface{} { return nil })
+	switch algo {
+	case LimitSlidingLog:
+		sl, ok := ent.value.(*slidingLog)
+		if !ok || len(sl.times) != limit {
+			sl = &slidingLog{times: make([]int64, limit)}
+			ent.value = sl
+		}
+		return sl.allowN(now, int64(window), limit, n)
+	default:
+		wc, ok := ent.value.(*windowCounter)
+		if !ok {
+			wc = &windowCounter{}
+			ent.value = wc
+		}
+		return wc.allowN(now, int64(window), limit, n, algo == LimitSlidingWindow)
+	}
+}
+
+// touch key, 更新key的修改版本号
+func (fc *fasterCache) touch(key string) {
+	if e, ok := fc.dataMap[key]; ok {
+		fc.seq++
+		e.Value.(*entry).version = fc.seq
+	}
+}
+
+// version key, 返回key的修改版本号，key不存在或已过期返回false
+func (fc *fasterCache) version(key string) (uint64, bool) {
+	if e, ok := fc.dataMap[key]; ok {
+		ent := e.Value.(*entry)
+		if ent.expiration >= time.Now().UnixNano() {
+			return ent.version, true
+		}
+	}
+	return 0, false
+}
+
 // remove tail
 func (fc *fasterCache) removeTail() {
 	e := fc.evictList.Back()
@@ -0,0 +1,204 @@
+package sds
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+const (
+	filterMagic   = "SDSF"
+	filterVersion = 1
+
+	maxCounter = math.MaxUint8
+	// maxFilterBytes 恢复的过滤器最多占用的字节数，避免损坏的快照申请过多内存
+	maxFilterBytes = 1 << 30
+	// maxFilterHashes 恢复的过滤器最多的哈希函数个数
+	maxFilterHashes = 64
+)
+
+// CountingBloom 分片的计数布隆过滤器，支持删除
+// Test 返回false时key一定不存在，返回true时key可能存在
+type CountingBloom struct {
+	seed   uint32
+	num    uint32
+	k      uint32
+	m      uint32
+	mus    []sync.RWMutex
+	shards [][]uint8
+}
+
+// NewCountingBloom 按预计元素数量n和误判率fpRate创建过滤器，num为分片数
+func NewCountingBloom(num uint32, n uint, fpRate float64) *CountingBloom {
+	if num == 0 {
+		num = 1
+	}
+	if n == 0 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	//总计数器个数 m = -n*ln(p)/(ln2)^2，哈希函数个数 k = m/n*ln2
+	total := math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2))
+	k := uint32(math.Max(1, math.Round(total/float64(n)*math.Ln2)))
+	m := uint32(math.Ceil(total / float64(num)))
+	return newCountingBloom(newSeed(), num, k, m)
+}
+
+func newCountingBloom(seed, num, k, m uint32) *CountingBloom {
+	cb := &CountingBloom{
+		seed:   seed,
+		num:    num,
+		k:      k,
+		m:      m,
+		mus:    make([]sync.RWMutex, num),
+		shards: make([][]uint8, num),
+	}
+	for i := uint32(0); i < num; i++ {
+		cb.shards[i] = make([]uint8, m)
+	}
+	return cb
+}
+
+func (cb *CountingBloom) idx(k string) uint32 {
+	return djb33(cb.seed, k) % cb.num
+}
+
+// locations 双重哈希计算key在分片内的k个位置
+func (cb *CountingBloom) locations(key string) []uint32 {
+	sum := hash64(key)
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	locs := make([]uint32, cb.k)
+	for i := uint32(0); i < cb.k; i++ {
+		locs[i] = (h1 + i*h2) % cb.m
+	}
+	return locs
+}
+
+// Add 添加key
+func (cb *CountingBloom) Add(key string) {
+	i := cb.idx(key)
+	locs := cb.locations(key)
+	cb.mus[i].Lock()
+	defer cb.mus[i].Unlock()
+	for _, l := range locs {
+		if cb.shards[i][l] < maxCounter {
+			cb.shards[i][l]++
+		}
+	}
+}
+
+// Test key可能存在返回true
+func (cb *CountingBloom) Test(key string) bool {
+	i := cb.idx(key)
+	locs := cb.locations(key)
+	cb.mus[i].RLock()
+	defer cb.mus[i].RUnlock()
+	for _, l := range locs {
+		if cb.shards[i][l] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove 删除key，只能删除Add过的key，否则会引入漏判
+func (cb *CountingBloom) Remove(key string) {
+	i := cb.idx(key)
+	locs := cb.locations(key)
+	cb.mus[i].Lock()
+	defer cb.mus[i].Unlock()
+	//先确认所有位置都不为0，避免删除不存在的key
+	for _, l := range locs {
+		if cb.shards[i][l] == 0 {
+			return
+		}
+	}
+	for _, l := range locs {
+		//计数器饱和后不再递减
+		if cb.shards[i][l] < maxCounter {
+			cb.shards[i][l]--
+		}
+	}
+}
+
+// Reset 清空过滤器
+func (cb *CountingBloom) Reset() {
+	for i := uint32(0); i < cb.num; i++ {
+		cb.mus[i].Lock()
+		for j := range cb.shards[i] {
+			cb.shards[i][j] = 0
+		}
+		cb.mus[i].Unlock()
+	}
+}
+
+// WriteTo 序列化过滤器，可以和缓存快照一起持久化
+func (cb *CountingBloom) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	header := make([]byte, 0, len(filterMagic)+1+16)
+	header = append(header, filterMagic...)
+	header = append(header, filterVersion)
+	header = binary.BigEndian.AppendUint32(header, cb.seed)
+	header = binary.BigEndian.AppendUint32(header, cb.num)
+	header = binary.BigEndian.AppendUint32(header, cb.k)
+	header = binary.BigEndian.AppendUint32(header, cb.m)
+	c, err := bw.Write(header)
+	n += int64(c)
+	if err != nil {
+		return n, err
+	}
+	for i := uint32(0); i < cb.num; i++ {
+		cb.mus[i].RLock()
+		c, err = bw.Write(cb.shards[i])
+		cb.mus[i].RUnlock()
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// ReadCountingBloom 从WriteTo的输出恢复过滤器
+func ReadCountingBloom(r io.Reader) (*CountingBloom, error) {
+	header := make([]byte, len(filterMagic)+1+16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read filter header failed, err:%w", err)
+	}
+	if string(header[:len(filterMagic)]) != filterMagic {
+		return nil, errors.New("invalid filter data")
+	}
+	if header[len(filterMagic)] != filterVersion {
+		return nil, fmt.Errorf("unsupported filter version %d", header[len(filterMagic)])
+	}
+	p := header[len(filterMagic)+1:]
+	seed := binary.BigEndian.Uint32(p[0:4])
+	num := binary.BigEndian.Uint32(p[4:8])
+	k := binary.BigEndian.Uint32(p[8:12])
+	m := binary.BigEndian.Uint32(p[12:16])
+	if num == 0 || k == 0 || m == 0 || k > maxFilterHashes {
+		return nil, errors.New("invalid filter data")
+	}
+	size := uint64(num) * uint64(m)
+	if size > maxFilterBytes {
+		return nil, fmt.Errorf("filter size %d exceeds max size %d", size, maxFilterBytes)
+	}
+	//可以知道剩余长度时，先检查数据是否完整
+	if l, ok := r.(interface{ Len() int }); ok && uint64(l.Len()) < size {
+		return nil, fmt.Errorf("filter data truncated, need %d bytes, got %d", size, l.Len())
+	}
+	cb := newCountingBloom(seed, num, k, m)
+	for i := uint32(0); i < num; i++ {
+		if _, err := io.ReadFull(r, cb.shards[i]); err != nil {
+			return nil, fmt.Errorf("read filter shard %d failed, err:%w", i, err)
+		}
+	}
+	return cb, nil
+}
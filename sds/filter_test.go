@@ -0,0 +1,107 @@
+package sds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCountingBloom(t *testing.T) {
+	cb := NewCountingBloom(4, uint(len(agentList)), 0.01)
+	for _, id := range agentList {
+		cb.Add(id)
+	}
+	for _, id := range agentList {
+		if !cb.Test(id) {
+			t.Errorf("expected %s in filter", id)
+		}
+	}
+
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if cb.Test("missing-" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if fp > 200 {
+		t.Errorf("too many false positives: %d", fp)
+	}
+
+	small := NewCountingBloom(1, 100, 0.001)
+	small.Add(agentList[0])
+	small.Add(agentList[1])
+	small.Remove(agentList[0])
+	if small.Test(agentList[0]) {
+		t.Errorf("expected %s removed", agentList[0])
+	}
+	if !small.Test(agentList[1]) {
+		t.Errorf("expected %s still in filter", agentList[1])
+	}
+}
+
+func TestCountingBloomSerialize(t *testing.T) {
+	cb := NewCountingBloom(4, 100, 0.01)
+	for _, id := range agentList {
+		cb.Add(id)
+	}
+	var buf bytes.Buffer
+	if _, err := cb.WriteTo(&buf); err != nil {
+		t.Fatalf("write filter failed: %v", err)
+	}
+	restored, err := ReadCountingBloom(&buf)
+	if err != nil {
+		t.Fatalf("read filter failed: %v", err)
+	}
+	for _, id := range agentList {
+		if !restored.Test(id) {
+			t.Errorf("expected %s in restored filter", id)
+		}
+	}
+	if _, err = ReadCountingBloom(bytes.NewReader([]byte("bad"))); err == nil {
+		t.Error("expected error for invalid data")
+	}
+
+	//损坏的头部声明过大的尺寸，或者数据被截断
+	header := func(num, k, m uint32) []byte {
+		b := append([]byte(filterMagic), filterVersion)
+		for _, v := range []uint32{1, num, k, m} {
+			b = binary.BigEndian.AppendUint32(b, v)
+		}
+		return b
+	}
+	if _, err = ReadCountingBloom(bytes.NewReader(header(math.MaxUint32, 3, math.MaxUint32))); err == nil {
+		t.Error("expected error for oversized filter")
+	}
+	if _, err = ReadCountingBloom(bytes.NewReader(append(header(1, 3, 1000), make([]byte, 10)...))); err == nil {
+		t.Error("expected error for truncated filter")
+	}
+}
+
+func TestGetOrLoadFilter(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	cb := NewCountingBloom(4, 100, 0.01)
+	cb.Add(agentList[0])
+	bc.SetFilter(cb)
+
+	calls := 0
+	loader := func(key string) (interface{}, error) {
+		calls++
+		return "agent:" + key, nil
+	}
+	v, err := bc.GetOrLoad(agentList[0], time.Minute, loader)
+	if err != nil || v != "agent:"+agentList[0] {
+		t.Fatalf("unexpected load result: %v, %v", v, err)
+	}
+	if _, err = bc.GetOrLoad(agentList[0], time.Minute, loader); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = bc.GetOrLoad(agentList[1], time.Minute, loader); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected loader called once, got %d", calls)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -88,3 +89,37 @@ func TestDefer(t *testing.T) {
 
 	return
 }
+
+func TestHGetAllSnapshot(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	bc.HMSet("agent", map[string]interface{}{"a": 1, "b": 2}, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			bc.HSet("agent", strconv.Itoa(i), i, 0)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for range bc.HGetAll("agent") {
+			}
+		}
+	}()
+	wg.Wait()
+
+	vals := bc.HMGet("agent", "a", "b", "missing")
+	if vals[0] != 1 || vals[1] != 2 || vals[2] != nil {
+		t.Errorf("unexpected HMGet result: %+v", vals)
+	}
+	n := 0
+	bc.HGetAllFunc("agent", func(hm map[string]interface{}) {
+		n = len(hm)
+	})
+	if n != 1002 {
+		t.Errorf("expected 1002 fields, got %d", n)
+	}
+}
@@ -0,0 +1,186 @@
+package sds
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	//hll 精度，2^14 个寄存器，标准误差约 0.81%
+	hllPrecision = 14
+	hllRegisters = 1 << hllPrecision
+
+	defaultCMSWidth = 2048
+	defaultCMSDepth = 5
+	defaultCMSTopK  = 10
+)
+
+// hash64 fnv-1a 再用 splitmix64 的混合函数打散
+func hash64(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	sum ^= sum >> 30
+	sum *= 0xbf58476d1ce4e5b9
+	sum ^= sum >> 27
+	sum *= 0x94d049bb133111eb
+	sum ^= sum >> 31
+	return sum
+}
+
+// hyperLogLog 基数估计
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, hllRegisters)}
+}
+
+// add 添加元素，寄存器有变化返回true
+func (h *hyperLogLog) add(element string) bool {
+	x := hash64(element)
+	idx := x >> (64 - hllPrecision)
+	//剩余位中第一个1的位置
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+		return true
+	}
+	return false
+}
+
+func (h *hyperLogLog) merge(o *hyperLogLog) {
+	for i, r := range o.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+func (h *hyperLogLog) clone() *hyperLogLog {
+	c := &hyperLogLog{registers: make([]uint8, hllRegisters)}
+	copy(c.registers, h.registers)
+	return c
+}
+
+func (h *hyperLogLog) count() int64 {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1.0 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	est := alpha * m * m / sum
+	//小基数使用线性计数修正
+	if est <= 2.5*m && zeros > 0 {
+		est = m * math.Log(m/float64(zeros))
+	}
+	return int64(est + 0.5)
+}
+
+// HeavyHitter count-min sketch 中的高频元素
+type HeavyHitter struct {
+	Item  string
+	Count int64
+}
+
+// countMinSketch 频率估计，并跟踪topK高频元素
+type countMinSketch struct {
+	width  uint32
+	depth  uint32
+	topK   int
+	counts [][]int64
+	heavy  map[string]int64
+}
+
+func newCountMinSketch(width, depth uint32, topK int) *countMinSketch {
+	if width == 0 {
+		width = defaultCMSWidth
+	}
+	if depth == 0 {
+		depth = defaultCMSDepth
+	}
+	if topK <= 0 {
+		topK = defaultCMSTopK
+	}
+	cms := &countMinSketch{
+		width:  width,
+		depth:  depth,
+		topK:   topK,
+		counts: make([][]int64, depth),
+		heavy:  make(map[string]int64, topK),
+	}
+	for i := range cms.counts {
+		cms.counts[i] = make([]int64, width)
+	}
+	return cms
+}
+
+// incrBy 增加计数，返回增加后的估计值
+func (c *countMinSketch) incrBy(item string, incr int64) int64 {
+	x := hash64(item)
+	h1, h2 := uint32(x), uint32(x>>32)|1
+	est := int64(math.MaxInt64)
+	for i := uint32(0); i < c.depth; i++ {
+		j := (h1 + i*h2) % c.width
+		c.counts[i][j] += incr
+		if c.counts[i][j] < est {
+			est = c.counts[i][j]
+		}
+	}
+	c.track(item, est)
+	return est
+}
+
+func (c *countMinSketch) query(item string) int64 {
+	x := hash64(item)
+	h1, h2 := uint32(x), uint32(x>>32)|1
+	est := int64(math.MaxInt64)
+	for i := uint32(0); i < c.depth; i++ {
+		j := (h1 + i*h2) % c.width
+		if c.counts[i][j] < est {
+			est = c.counts[i][j]
+		}
+	}
+	return est
+}
+
+// track 维护topK，新估计值超过当前最小值时替换
+func (c *countMinSketch) track(item string, est int64) {
+	if _, ok := c.heavy[item]; ok || len(c.heavy) < c.topK {
+		c.heavy[item] = est
+		return
+	}
+	minItem, minCount := "", int64(math.MaxInt64)
+	for k, v := range c.heavy {
+		if v < minCount {
+			minItem, minCount = k, v
+		}
+	}
+	if est > minCount {
+		delete(c.heavy, minItem)
+		c.heavy[item] = est
+	}
+}
+
+// list 按计数从大到小返回topK
+func (c *countMinSketch) list() []HeavyHitter {
+	hitters := make([]HeavyHitter, 0, len(c.heavy))
+	for k, v := range c.heavy {
+		hitters = append(hitters, HeavyHitter{Item: k, Count: v})
+	}
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Count != hitters[j].Count {
+			return hitters[i].Count > hitters[j].Count
+		}
+		return hitters[i].Item < hitters[j].Item
+	})
+	return hitters
+}
@@ -0,0 +1,64 @@
+package sds
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPFCount(t *testing.T) {
+	bc := NewBigCache(0, 16, 100, nil)
+	if !bc.PFAdd("tenant:a", 0, agentList...) {
+		t.Error("expected PFAdd to report change")
+	}
+	if bc.PFAdd("tenant:a", 0, agentList[0]) {
+		t.Error("expected PFAdd of existing element to report no change")
+	}
+	n := bc.PFCount("tenant:a")
+	if diff := n - int64(len(agentList)); diff > 30 || diff < -30 {
+		t.Errorf("expected about %d, got %d", len(agentList), n)
+	}
+
+	bc.PFAdd("tenant:b", 0, agentList[:100]...)
+	bc.PFAdd("tenant:b", 0, "extra")
+	if n = bc.PFCount("tenant:a", "tenant:b"); n < int64(len(agentList))-30 || n > int64(len(agentList))+30 {
+		t.Errorf("unexpected merged count %d", n)
+	}
+	bc.PFMerge("all", time.Minute, "tenant:a", "tenant:b")
+	if bc.PFCount("all") != bc.PFCount("tenant:a", "tenant:b") {
+		t.Errorf("PFMerge count mismatch")
+	}
+	if bc.DataType("all") != typeHLL {
+		t.Errorf("expected hll data type, got %d", bc.DataType("all"))
+	}
+}
+
+func TestPFExpire(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	bc.PFAdd("tenant", 50*time.Millisecond, "a", "b")
+	if bc.PFCount("tenant") != 2 {
+		t.Errorf("expected 2, got %d", bc.PFCount("tenant"))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if bc.PFCount("tenant") != 0 {
+		t.Errorf("expected expired key count 0, got %d", bc.PFCount("tenant"))
+	}
+}
+
+func TestCMSTopK(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	bc.CMSInit("talkers", 1024, 4, 3, 0)
+	for i := 0; i < 20; i++ {
+		for j := 0; j <= i; j++ {
+			bc.CMSIncrBy("talkers", "agent-"+strconv.Itoa(i), 1, 0)
+		}
+	}
+	counts := bc.CMSQuery("talkers", "agent-19", "agent-0", "missing")
+	if counts[0] < 20 || counts[1] < 1 || counts[2] != 0 {
+		t.Errorf("unexpected counts: %+v", counts)
+	}
+	top := bc.CMSTopK("talkers")
+	if len(top) != 3 || top[0].Item != "agent-19" || top[1].Item != "agent-18" || top[2].Item != "agent-17" {
+		t.Errorf("unexpected top k: %+v", top)
+	}
+}
@@ -0,0 +1,168 @@
+package sds
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrTxAborted watch的key在事务执行前被修改
+var ErrTxAborted = errors.New("sds: transaction aborted, watched key changed")
+
+const (
+	opSet = iota
+	opDel
+	opExpire
+	opIncrBy
+	opHSet
+	opHDel
+	opHMSet
+	opHIncrBy
+)
+
+type txOp struct {
+	op         int
+	key        string
+	subKey     string
+	value      interface{}
+	values     map[string]interface{}
+	incr       int64
+	expiration time.Duration
+}
+
+type watchedKey struct {
+	version uint64
+	exist   bool
+}
+
+// Tx 事务句柄，在 Multi/Watch 的闭包中排队写操作，Exec 时一次性执行
+type Tx struct {
+	bc      *BigCache
+	ops     []txOp
+	watched map[string]watchedKey
+}
+
+// Multi 在闭包中排队写操作，闭包返回nil后原子执行，返回每个操作的结果
+func (f *BigCache) Multi(fn func(tx *Tx) error) ([]interface{}, error) {
+	return f.Watch(fn)
+}
+
+// Watch 乐观事务，闭包执行期间keys被其它调用方修改时返回 ErrTxAborted，不执行任何操作
+func (f *BigCache) Watch(fn func(tx *Tx) error, keys ...string) ([]interface{}, error) {
+	tx := &Tx{
+		bc:      f,
+		watched: make(map[string]watchedKey, len(keys)),
+	}
+	for _, key := range keys {
+		i := f.idx(key)
+		f.mus[i].Lock()
+		v, ok := f.shards[i].version(key)
+		f.mus[i].Unlock()
+		tx.watched[key] = watchedKey{version: v, exist: ok}
+	}
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+	return tx.exec()
+}
+
+// Get 读取key，不加入事务队列
+func (tx *Tx) Get(key string) interface{} {
+	return tx.bc.Get(key)
+}
+
+// HGet 读取hash key的subKey，不加入事务队列
+func (tx *Tx) HGet(key, subKey string) interface{} {
+	return tx.bc.HGet(key, subKey)
+}
+
+func (tx *Tx) Set(key string, value interface{}, expiration time.Duration) {
+	tx.ops = append(tx.ops, txOp{op: opSet, key: key, value: value, expiration: expiration})
+}
+
+func (tx *Tx) Del(key string) {
+	tx.ops = append(tx.ops, txOp{op: opDel, key: key})
+}
+
+func (tx *Tx) Expire(key string, expiration time.Duration) {
+	tx.ops = append(tx.ops, txOp{op: opExpire, key: key, expiration: expiration})
+}
+
+func (tx *Tx) IncrBy(key string, incr int64) {
+	tx.ops = append(tx.ops, txOp{op: opIncrBy, key: key, incr: incr})
+}
+
+func (tx *Tx) HSet(key, subKey string, value interface{}, expiration time.Duration) {
+	tx.ops = append(tx.ops, txOp{op: opHSet, key: key, subKey: subKey, value: value, expiration: expiration})
+}
+
+func (tx *Tx) HMSet(key string, values map[string]interface{}, expiration time.Duration) {
+	tx.ops = append(tx.ops, txOp{op: opHMSet, key: key, values: values, expiration: expiration})
+}
+
+func (tx *Tx) HDel(key, subKey string) {
+	tx.ops = append(tx.ops, txOp{op: opHDel, key: key, subKey: subKey})
+}
+
+func (tx *Tx) HIncrBy(key, subKey string, incr int64, expiration time.Duration) {
+	tx.ops = append(tx.ops, txOp{op: opHIncrBy, key: key, subKey: subKey, incr: incr, expiration: expiration})
+}
+
+// exec 按分片序号从小到大加锁，检查watch的key后执行所有操作
+func (tx *Tx) exec() ([]interface{}, error) {
+	f := tx.bc
+	//收集涉及的分片，排序后加锁，避免死锁
+	idxSet := make(map[uint32]struct{})
+	for key := range tx.watched {
+		idxSet[f.idx(key)] = struct{}{}
+	}
+	for _, op := range tx.ops {
+		idxSet[f.idx(op.key)] = struct{}{}
+	}
+	idxs := make([]uint32, 0, len(idxSet))
+	for i := range idxSet {
+		idxs = append(idxs, i)
+	}
+	sort.Slice(idxs, func(a, b int) bool { return idxs[a] < idxs[b] })
+	for _, i := range idxs {
+		f.mus[i].Lock()
+	}
+	defer func() {
+		for j := len(idxs) - 1; j >= 0; j-- {
+			f.mus[idxs[j]].Unlock()
+		}
+	}()
+
+	//检查watch的key是否被修改
+	for key, w := range tx.watched {
+		v, ok := f.shards[f.idx(key)].version(key)
+		if ok != w.exist || v != w.version {
+			return nil, ErrTxAborted
+		}
+	}
+
+	results := make([]interface{}, len(tx.ops))
+	for n, op := range tx.ops {
+		sh := f.shards[f.idx(op.key)]
+		switch op.op {
+		case opSet:
+			sh.set(op.key, op.value, op.expiration)
+		case opDel:
+			sh.del(op.key)
+		case opExpire:
+			sh.expire(op.key, op.expiration)
+		case opIncrBy:
+			results[n] = sh.incrBy(op.key, op.incr)
+		case opHSet:
+			sh.hSet(op.key, op.subKey, op.value, op.expiration)
+		case opHMSet:
+			sh.hMSet(op.key, op.values, op.expiration)
+		case opHDel:
+			sh.hDel(op.key, op.subKey)
+		case opHIncrBy:
+			results[n] = sh.hIncrBy(op.key, op.subKey, op.incr, op.expiration)
+		}
+		sh.touch(op.key)
+	}
+	return results, nil
+}
@@ -0,0 +1,80 @@
+package sds
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMulti(t *testing.T) {
+	bc := NewBigCache(0, 16, 1000, nil)
+	bc.Set("cnt", int64(1), 0)
+
+	res, err := bc.Multi(func(tx *Tx) error {
+		tx.Set("agent:1", "online", time.Minute)
+		tx.IncrBy("cnt", 2)
+		tx.HIncrBy("agent:1:stat", "online", 1, 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("multi failed: %v", err)
+	}
+	if len(res) != 3 || res[1].(int64) != 3 || res[2].(int64) != 1 {
+		t.Errorf("unexpected results: %+v", res)
+	}
+	if bc.Get("agent:1") != "online" {
+		t.Errorf("expected agent:1 online, got %v", bc.Get("agent:1"))
+	}
+}
+
+func TestMultiConcurrent(t *testing.T) {
+	bc := NewBigCache(0, 16, 1000, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = bc.Multi(func(tx *Tx) error {
+				tx.HIncrBy("a", "n", 1, 0)
+				tx.HIncrBy("b", "n", 1, 0)
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if bc.HGet("a", "n").(int64) != 50 || bc.HGet("b", "n").(int64) != 50 {
+		t.Errorf("expected 50/50, got %v/%v", bc.HGet("a", "n"), bc.HGet("b", "n"))
+	}
+}
+
+func TestWatchAbort(t *testing.T) {
+	bc := NewBigCache(0, 16, 1000, nil)
+	bc.Set("status", "idle", 0)
+
+	_, err := bc.Watch(func(tx *Tx) error {
+		if tx.Get("status") != "idle" {
+			t.Errorf("expected idle")
+		}
+		//其它调用方修改了watch的key
+		bc.Set("status", "busy", 0)
+		tx.Set("status", "running", 0)
+		return nil
+	}, "status")
+	if err != ErrTxAborted {
+		t.Fatalf("expected ErrTxAborted, got %v", err)
+	}
+	if bc.Get("status") != "busy" {
+		t.Errorf("expected busy, got %v", bc.Get("status"))
+	}
+
+	_, err = bc.Watch(func(tx *Tx) error {
+		tx.Set("status", "running", 0)
+		return nil
+	}, "status")
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	if bc.Get("status") != "running" {
+		t.Errorf("expected running, got %v", bc.Get("status"))
+	}
+}
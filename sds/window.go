@@ -0,0 +1,103 @@
+package sds
+
+import (
+	"time"
+)
+
+const (
+	// LimitSlidingLog 记录窗口内每次请求的时间，精确但内存随limit增长
+	LimitSlidingLog = iota
+	// LimitSlidingWindow 按上一窗口计数加权估计，每个key固定内存
+	LimitSlidingWindow
+	// LimitFixedWindow 固定窗口计数
+	LimitFixedWindow
+)
+
+// slidingLog 窗口内请求时间的环形队列
+type slidingLog struct {
+	times []int64
+	head  int
+	size  int
+}
+
+func (s *slidingLog) allowN(now, window int64, limit, n int) bool {
+	//移除窗口外的记录
+	for s.size > 0 && s.times[s.head] <= now-window {
+		s.head = (s.head + 1) % len(s.times)
+		s.size--
+	}
+	if s.size+n > limit {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		s.times[(s.head+s.size)%len(s.times)] = now
+		s.size++
+	}
+	return true
+}
+
+// windowCounter 当前窗口和上一窗口的计数
+type windowCounter struct {
+	start int64
+	curr  int64
+	prev  int64
+}
+
+func (c *windowCounter) allowN(now, window int64, limit, n int, sliding bool) bool {
+	start := now - now%window
+	if start != c.start {
+		if start-c.start == window {
+			c.prev = c.curr
+		} else {
+			c.prev = 0
+		}
+		c.curr = 0
+		c.start = start
+	}
+	count := float64(c.curr)
+	if sliding {
+		//上一窗口计数按剩余重叠比例加权
+		count += float64(c.prev) * float64(window-(now-start)) / float64(window)
+	}
+	if count+float64(n) > float64(limit) {
+		return false
+	}
+	c.curr += int64(n)
+	return true
+}
+
+// WindowLimiter 基于 BigCache 的进程内限流器，空闲key依靠缓存过期自动清理
+type WindowLimiter struct {
+	bc     *BigCache
+	algo   int
+	limit  int
+	window time.Duration
+}
+
+// NewWindowLimiter 创建限流器，每个key在window内最多允许limit次请求
+func NewWindowLimiter(bc *BigCache, algo int, limit int, window time.Duration) *WindowLimiter {
+	if bc == nil || limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &WindowLimiter{
+		bc:     bc,
+		algo:   algo,
+		limit:  limit,
+		window: window,
+	}
+}
+
+func (l *WindowLimiter) Allow(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN 一次申请n个配额，不足时不消耗
+func (l *WindowLimiter) AllowN(key string, n int) bool {
+	if n <= 0 {
+		return true
+	}
+	i := l.bc.idx(key)
+	l.bc.mus[i].Lock()
+	defer l.bc.mus[i].Unlock()
+	return l.bc.shards[i].windowAllowN(key, l.algo, l.limit, l.window, n)
+}
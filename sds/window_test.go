@@ -0,0 +1,52 @@
+package sds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowLimiter(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	for _, algo := range []int{LimitSlidingLog, LimitSlidingWindow, LimitFixedWindow} {
+		l := NewWindowLimiter(bc, algo, 5, time.Hour)
+		key := "user:" + string(rune('a'+algo))
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			if l.Allow(key) {
+				allowed++
+			}
+		}
+		if allowed != 5 {
+			t.Errorf("algo %d: expected 5 allowed, got %d", algo, allowed)
+		}
+		if l.AllowN("other", 6) {
+			t.Errorf("algo %d: expected AllowN over limit to fail", algo)
+		}
+		bc.Del("other")
+	}
+}
+
+func TestSlidingLogRecover(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	l := NewWindowLimiter(bc, LimitSlidingLog, 2, 100*time.Millisecond)
+	if !l.Allow("ip") || !l.Allow("ip") || l.Allow("ip") {
+		t.Fatal("expected 2 allowed then rejected")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !l.Allow("ip") {
+		t.Error("expected allowed after window passed")
+	}
+}
+
+func TestWindowLimiterIdleExpire(t *testing.T) {
+	bc := NewBigCache(0, 4, 100, nil)
+	l := NewWindowLimiter(bc, LimitFixedWindow, 1, 50*time.Millisecond)
+	l.Allow("agent")
+	if !bc.Exist("agent") {
+		t.Fatal("expected limiter key in cache")
+	}
+	time.Sleep(80 * time.Millisecond)
+	if bc.Exist("agent") {
+		t.Error("expected idle limiter key expired")
+	}
+}